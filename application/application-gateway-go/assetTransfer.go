@@ -103,15 +103,36 @@ import (
 )
 
 const (
-	mspID        = "POSBusinessMSP"
 	cryptoPath   = "../../organizations/peerOrganizations/pos.com"
-	certPath     = cryptoPath + "/users/User1@pos.com/msp/signcerts"
-	keyPath      = cryptoPath + "/users/User1@pos.com/msp/keystore"
 	tlsCertPath  = cryptoPath + "/peers/peer0.pos.com/tls/ca.crt"
 	peerEndpoint = "dns:///localhost:7051"
 	gatewayPeer  = "peer0.pos.com"
 )
 
+// signer is an identity of the org: its certificate file and the directory
+// holding its private key.
+type signer struct {
+	certPath string
+	keyPath  string
+}
+
+// The chaincode checks each caller's role, so the sample signs each call as
+// the identity whose role allows it: the org admin registers the commission
+// plan and restaurant, Terminal1 (pos-terminal) records the sales and User1
+// (finance) reads them back. network.sh enrols Terminal1 and User1 with their
+// pos.role; the POS_* variables name other identities.
+var (
+	mspID          = envOr("POS_MSP_ID", "POSBusinessMSP")
+	adminSigner    = signer{envOr("POS_ADMIN_CERT_PATH", cryptoPath+"/users/Admin@pos.com/msp/signcerts/Admin@pos.com-cert.pem"), envOr("POS_ADMIN_KEY_DIR", cryptoPath+"/users/Admin@pos.com/msp/keystore")}
+	terminalSigner = signer{envOr("POS_TERMINAL_CERT_PATH", cryptoPath+"/users/Terminal1@pos.com/msp/signcerts/cert.pem"), envOr("POS_TERMINAL_KEY_DIR", cryptoPath+"/users/Terminal1@pos.com/msp/keystore")}
+	financeSigner  = signer{envOr("POS_CERT_PATH", cryptoPath+"/users/User1@pos.com/msp/signcerts/cert.pem"), envOr("POS_KEY_DIR", cryptoPath+"/users/User1@pos.com/msp/keystore")}
+)
+
+// standardPlan is the commission plan the sample's restaurant is registered
+// on, the same one network.sh sets up: 10% commission plus a 1.5% + 0.20 GBP
+// processor fee.
+const standardPlan = `{"id":"standard","type":"percentage","rate_bps":1000,"processor_rate_bps":150,"processor_fixed_fee":{"minor_units":20,"currency":"GBP"}}`
+
 var now = time.Now()
 var assetId = fmt.Sprintf("asset%d", now.Unix()*1e3+int64(now.Nanosecond())/1e6)
 
@@ -119,12 +140,56 @@ func main() {
 	clientConnection := newGrpcConnection()
 	defer clientConnection.Close()
 
-	id := newIdentity()
-	sign := newSign()
+	adminGw := connect(clientConnection, adminSigner)
+	defer adminGw.Close()
+	terminalGw := connect(clientConnection, terminalSigner)
+	defer terminalGw.Close()
+	financeGw := connect(clientConnection, financeSigner)
+	defer financeGw.Close()
+
+	// Configuration
+	chaincodeName := "poscontract"
+	channelName := "poschannel"
+
+	adminContract := adminGw.GetNetwork(channelName).GetContract(chaincodeName)
+	terminalContract := terminalGw.GetNetwork(channelName).GetContract(chaincodeName)
+	financeNetwork := financeGw.GetNetwork(channelName)
+	financeContract := financeNetwork.GetContract(chaincodeName)
+
+	// Print chaincode events as the transactions below are committed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startChaincodeEventListening(ctx, financeNetwork, chaincodeName)
+
+	// Sales can only be recorded for registered restaurants, and a restaurant
+	// can only be registered on a commission plan that exists
+	ensureCommissionPlan(adminContract, "standard", standardPlan)
+	ensureRestaurant(adminContract, "YoTech_Cafe", "YoTech Cafe", "YoTech Ltd", "GBP", "standard")
+	contract := terminalContract
+
+	uniqueID := fmt.Sprintf("TX_POS_%d", time.Now().Unix())
+	// Create a new transaction
+	// Arguments: ID, RestaurantID, Amount, Currency, StripeID, BusinessTime
+	recordTransaction(contract, uniqueID, "YoTech_Cafe", "125.50", "GBP", "ch_stripe_new_999", time.Now().Format(time.RFC3339),
+		`{"items":[{"sku":"LATTE-L","quantity":2,"unit_price":"50.00"}],"taxes":[{"name":"VAT","rate_bps":2000}],"tip":"5.50"}`)
+
+	// Upload sales taken offline in one transaction, keeping the valid ones
+	recordTransactionsBatch(contract, []batchTransaction{
+		{ID: uniqueID + "_1", RestaurantID: "YoTech_Cafe", Amount: "8.40", Currency: "GBP", BusinessTime: time.Now().Add(-2 * time.Hour).Format(time.RFC3339)},
+		{ID: uniqueID + "_2", RestaurantID: "YoTech_Cafe", Amount: "23.95", Currency: "GBP", BusinessTime: time.Now().Add(-time.Hour).Format(time.RFC3339)},
+	}, "best-effort")
+
+	// Let's fetch an existing record
+	// Note: Change "tx101" to an ID you know exists in your ledger
+	// fetchRecordByID(financeContract, "STRIPE_100")
+	getAllRecords(financeContract)
+}
 
+// connect opens a Gateway connection, over clientConnection, that signs as s.
+func connect(clientConnection *grpc.ClientConn, s signer) *client.Gateway {
 	gw, err := client.Connect(
-		id,
-		client.WithSign(sign),
+		newIdentity(s.certPath),
+		client.WithSign(newSign(s.keyPath)),
 		client.WithHash(hash.SHA256),
 		client.WithClientConnection(clientConnection),
 		client.WithEvaluateTimeout(5*time.Second),
@@ -134,33 +199,76 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	defer gw.Close()
+	return gw
+}
 
-	// Configuration
-	chaincodeName := "poscontract"
-	channelName := "poschannel"
+// startChaincodeEventListening prints every event the chaincode emits until
+// ctx is cancelled. Each payload is a versioned JSON envelope holding the
+// changed record.
+func startChaincodeEventListening(ctx context.Context, network *client.Network, chaincodeName string) {
+	fmt.Println("\n*** Start chaincode event listening")
 
-	network := gw.GetNetwork(channelName)
-	contract := network.GetContract(chaincodeName)
+	events, err := network.ChaincodeEvents(ctx, chaincodeName)
+	if err != nil {
+		panic(fmt.Errorf("failed to start chaincode event listening: %w", err))
+	}
 
-	uniqueID := fmt.Sprintf("TX_POS_%d", time.Now().Unix())
-	// Create a new transaction
-	// Arguments: ID, RestaurantID, Amount, StripeID
-	recordTransaction(contract, uniqueID, "YoTech_Cafe", "125.50", "ch_stripe_new_999")
+	go func() {
+		for event := range events {
+			fmt.Printf("\n<-- Chaincode event received: %s (block %d)\n%s\n", event.EventName, event.BlockNumber, formatJSON(event.Payload))
+		}
+	}()
+}
 
-	// Let's fetch an existing record
-	// Note: Change "tx101" to an ID you know exists in your ledger
-	// fetchRecordByID(contract, "STRIPE_100")
-	getAllRecords(contract)
+// ensureCommissionPlan sets the commission plan unless it is already on the
+// ledger. contract must sign as an admin.
+func ensureCommissionPlan(contract *client.Contract, id string, planJSON string) {
+	if _, err := contract.EvaluateTransaction("GetCommissionPlan", id); err == nil {
+		return
+	}
+
+	fmt.Printf("\n--> Submit Transaction: SetCommissionPlan, ID: %s\n", id)
+
+	_, err := contract.Submit("SetCommissionPlan",
+		client.WithArguments(planJSON),
+		client.WithEndorsingOrganizations("POSBusinessMSP"),
+	)
+	if err != nil {
+		panic(fmt.Errorf("failed to submit transaction: %w", err))
+	}
+
+	fmt.Printf("*** Commission plan set successfully\n")
 }
 
-// recordTransaction adds a new POS transaction to the ledger
-func recordTransaction(contract *client.Contract, id string, restaurantID string, amount string, stripeID string) {
+// ensureRestaurant registers the restaurant unless it is already on the
+// ledger. contract must sign as an admin.
+func ensureRestaurant(contract *client.Contract, id string, name string, legalEntity string, currency string, commissionPlan string) {
+	if _, err := contract.EvaluateTransaction("GetRestaurant", id); err == nil {
+		return
+	}
+
+	fmt.Printf("\n--> Submit Transaction: RegisterRestaurant, ID: %s\n", id)
+
+	_, err := contract.Submit("RegisterRestaurant",
+		client.WithArguments(id, name, legalEntity, currency, commissionPlan),
+		client.WithEndorsingOrganizations("POSBusinessMSP"),
+	)
+	if err != nil {
+		panic(fmt.Errorf("failed to submit transaction: %w", err))
+	}
+
+	fmt.Printf("*** Restaurant registered successfully\n")
+}
+
+// recordTransaction adds a new POS transaction to the ledger, itemised by the
+// optional breakdown JSON. contract must sign as a pos-terminal. It is submitted in idempotent mode so retrying
+// after a timeout returns the stored sale.
+func recordTransaction(contract *client.Contract, id string, restaurantID string, amount string, currency string, stripeID string, businessTime string, breakdown string) {
 	fmt.Printf("\n--> Submit Transaction: RecordTransaction, ID: %s\n", id)
 
 	// Use .Submit instead of .SubmitTransaction to use ProposalOptions
 	_, err := contract.Submit("RecordTransaction",
-		client.WithArguments(id, restaurantID, amount, stripeID),
+		client.WithArguments(id, restaurantID, amount, currency, stripeID, businessTime, breakdown, "true"),
 		client.WithEndorsingOrganizations("POSBusinessMSP"),
 	)
 
@@ -171,6 +279,37 @@ func recordTransaction(contract *client.Contract, id string, restaurantID string
 	fmt.Printf("*** Transaction committed successfully\n")
 }
 
+// batchTransaction is one sale in a RecordTransactionsBatch upload.
+type batchTransaction struct {
+	ID              string `json:"id"`
+	RestaurantID    string `json:"restaurant_id"`
+	Amount          string `json:"amount"`
+	Currency        string `json:"currency"`
+	StripePaymentID string `json:"stripe_payment_id,omitempty"`
+	BusinessTime    string `json:"business_time,omitempty"`
+}
+
+// recordTransactionsBatch records the sales in a single transaction. mode is
+// "atomic" or "best-effort"; the result reports each sale's outcome. contract
+// must sign as a pos-terminal.
+func recordTransactionsBatch(contract *client.Contract, sales []batchTransaction, mode string) {
+	fmt.Printf("\n--> Submit Transaction: RecordTransactionsBatch, %d sales, mode: %s\n", len(sales), mode)
+
+	salesJSON, err := json.Marshal(sales)
+	if err != nil {
+		panic(err)
+	}
+	result, err := contract.Submit("RecordTransactionsBatch",
+		client.WithArguments(string(salesJSON), mode, "true"),
+		client.WithEndorsingOrganizations("POSBusinessMSP"),
+	)
+	if err != nil {
+		panic(fmt.Errorf("failed to submit transaction: %w", err))
+	}
+
+	fmt.Printf("*** Result:%s\n", formatJSON(result))
+}
+
 // fetchRecordByID calls the GetRecord function in your chaincode
 func fetchRecordByID(contract *client.Contract, id string) {
 	fmt.Printf("\n--> Evaluate Transaction: GetRecord, function returns record attributes for ID: %s\n", id)
@@ -185,33 +324,44 @@ func fetchRecordByID(contract *client.Contract, id string) {
 	fmt.Printf("*** Result: %s\n", string(evaluateResult))
 }
 
-// fetch all records
+// fetch all records, a page at a time
 func getAllRecords(contract *client.Contract) {
-	fmt.Println("\n--> Evaluate Transaction: GetAllRecords")
+	fmt.Println("\n--> Evaluate Transaction: GetAllRecordsPage")
 
-	evaluateResult, err := contract.EvaluateTransaction("GetAllRecords")
-	if err != nil {
-		panic(fmt.Errorf("failed to evaluate transaction: %w", err))
+	var page struct {
+		Records  []string `json:"records"`
+		Bookmark string   `json:"bookmark"`
 	}
+	count := 0
+	for {
+		evaluateResult, err := contract.EvaluateTransaction("GetAllRecordsPage", "", "100", page.Bookmark)
+		if err != nil {
+			panic(fmt.Errorf("failed to evaluate transaction: %w", err))
+		}
 
-	// The result is a JSON array of strings
-	var records []string
-	err = json.Unmarshal(evaluateResult, &records)
-	if err != nil {
-		panic(fmt.Errorf("failed to unmarshal records: %w", err))
-	}
+		page.Bookmark = ""
+		err = json.Unmarshal(evaluateResult, &page)
+		if err != nil {
+			panic(fmt.Errorf("failed to unmarshal records: %w", err))
+		}
 
-	fmt.Printf("*** Found %d records:\n", len(records))
-	for _, record := range records {
-		fmt.Printf("- %s\n", record)
+		for _, record := range page.Records {
+			fmt.Printf("- %s\n", record)
+		}
+		count += len(page.Records)
+		if page.Bookmark == "" {
+			break
+		}
 	}
+	fmt.Printf("*** Found %d records\n", count)
 }
 
-func updateTransaction(contract *client.Contract, id string, restaurantID string, amount string, stripeID string) {
+// updateTransaction corrects a sale. contract must sign as an admin.
+func updateTransaction(contract *client.Contract, id string, restaurantID string, amount string, stripeID string, reason string) {
 	fmt.Printf("\n--> Submit Transaction: UpdateTransaction, ID: %s\n", id)
 
 	_, err := contract.Submit("UpdateTransaction",
-		client.WithArguments(id, restaurantID, amount, stripeID),
+		client.WithArguments(id, restaurantID, amount, stripeID, reason),
 		client.WithEndorsingOrganizations("POSBusinessMSP"),
 	)
 
@@ -222,11 +372,12 @@ func updateTransaction(contract *client.Contract, id string, restaurantID string
 	fmt.Printf("*** Transaction updated successfully\n")
 }
 
-func deleteTransaction(contract *client.Contract, id string) {
-	fmt.Printf("\n--> Submit Transaction: DeleteTransaction, ID: %s\n", id)
+// voidTransaction voids a sale. contract must sign as an admin.
+func voidTransaction(contract *client.Contract, id string, reason string) {
+	fmt.Printf("\n--> Submit Transaction: VoidTransaction, ID: %s\n", id)
 
-	_, err := contract.Submit("DeleteTransaction",
-		client.WithArguments(id),
+	_, err := contract.Submit("VoidTransaction",
+		client.WithArguments(id, reason),
 		client.WithEndorsingOrganizations("POSBusinessMSP"),
 	)
 
@@ -234,7 +385,7 @@ func deleteTransaction(contract *client.Contract, id string) {
 		panic(fmt.Errorf("failed to submit transaction: %w", err))
 	}
 
-	fmt.Printf("*** Transaction deleted successfully\n")
+	fmt.Printf("*** Transaction voided successfully\n")
 }
 
 // newGrpcConnection creates a gRPC connection to the Gateway server.
@@ -261,9 +412,9 @@ func newGrpcConnection() *grpc.ClientConn {
 	return connection
 }
 
-// newIdentity creates a client identity for this Gateway connection using an X.509 certificate.
-func newIdentity() *identity.X509Identity {
-	certificatePEM, err := readFirstFile(certPath)
+// newIdentity creates a client identity for a Gateway connection using the X.509 certificate at certPath.
+func newIdentity(certPath string) *identity.X509Identity {
+	certificatePEM, err := os.ReadFile(certPath)
 	if err != nil {
 		panic(fmt.Errorf("failed to read certificate file: %w", err))
 	}
@@ -282,7 +433,7 @@ func newIdentity() *identity.X509Identity {
 }
 
 // newSign creates a function that generates a digital signature from a message digest using a private key.
-func newSign() identity.Sign {
+func newSign(keyPath string) identity.Sign {
 	privateKeyPEM, err := readFirstFile(keyPath)
 	if err != nil {
 		panic(fmt.Errorf("failed to read private key file: %w", err))
@@ -301,6 +452,15 @@ func newSign() identity.Sign {
 	return sign
 }
 
+// envOr returns the value of the environment variable name, or fallback if
+// it is not set.
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
 func readFirstFile(dirPath string) ([]byte, error) {
 	dir, err := os.Open(dirPath)
 	if err != nil {
@@ -2,19 +2,28 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"rest-api-go/web"
 )
 
 func main() {
 	cryptoPath := "/home/yotech-65/go/src/github.com/mehadi-yotech/pos-network/organizations/peerOrganizations/pos.com"
+	// The API submits sales as Terminal1 (pos-terminal), admin functions as
+	// the org admin and everything else as User1 (finance). network.sh enrols
+	// Terminal1 and User1 with their pos.role; the POS_* variables name other
+	// identities.
 	orgConfig := web.OrgSetup{
-		OrgName:      "pos.com",
-		MSPID:        "POSBusinessMSP",
-		CertPath:     cryptoPath + "/users/User1@pos.com/msp/signcerts/User1@pos.com-cert.pem",
-		KeyPath:      cryptoPath + "/users/User1@pos.com/msp/keystore/",
-		TLSCertPath:  cryptoPath + "/peers/peer0.pos.com/tls/ca.crt",
-		PeerEndpoint: "dns:///localhost:7051",
-		GatewayPeer:  "peer0.pos.com",
+		OrgName:          "pos.com",
+		MSPID:            envOr("POS_MSP_ID", "POSBusinessMSP"),
+		CertPath:         envOr("POS_CERT_PATH", cryptoPath+"/users/User1@pos.com/msp/signcerts/cert.pem"),
+		KeyPath:          envOr("POS_KEY_DIR", cryptoPath+"/users/User1@pos.com/msp/keystore/"),
+		TerminalCertPath: envOr("POS_TERMINAL_CERT_PATH", cryptoPath+"/users/Terminal1@pos.com/msp/signcerts/cert.pem"),
+		TerminalKeyPath:  envOr("POS_TERMINAL_KEY_DIR", cryptoPath+"/users/Terminal1@pos.com/msp/keystore/"),
+		AdminCertPath:    envOr("POS_ADMIN_CERT_PATH", cryptoPath+"/users/Admin@pos.com/msp/signcerts/Admin@pos.com-cert.pem"),
+		AdminKeyPath:     envOr("POS_ADMIN_KEY_DIR", cryptoPath+"/users/Admin@pos.com/msp/keystore/"),
+		TLSCertPath:      cryptoPath + "/peers/peer0.pos.com/tls/ca.crt",
+		PeerEndpoint:     "dns:///localhost:7051",
+		GatewayPeer:      "peer0.pos.com",
 	}
 
 	orgSetup, err := web.Initialize(orgConfig)
@@ -23,3 +32,12 @@ func main() {
 	}
 	web.Serve(web.OrgSetup(*orgSetup))
 }
+
+// envOr returns the value of the environment variable name, or fallback if
+// it is not set.
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -7,16 +7,64 @@ import (
 	"github.com/hyperledger/fabric-gateway/pkg/client"
 )
 
+// OrgSetup connects to the org's gateway peer as three identities, since the
+// chaincode checks each caller's role: Gateway signs as the finance user,
+// TerminalGateway as a pos-terminal and AdminGateway as the org admin.
 type OrgSetup struct {
-	OrgName      string
-	MSPID        string
-	CryptoPath   string
-	CertPath     string
-	KeyPath      string
-	TLSCertPath  string
-	PeerEndpoint string
-	GatewayPeer  string
-	Gateway      client.Gateway
+	OrgName          string
+	MSPID            string
+	CryptoPath       string
+	CertPath         string
+	KeyPath          string
+	TerminalCertPath string
+	TerminalKeyPath  string
+	AdminCertPath    string
+	AdminKeyPath     string
+	TLSCertPath      string
+	PeerEndpoint     string
+	GatewayPeer      string
+	Gateway          client.Gateway
+	TerminalGateway  client.Gateway
+	AdminGateway     client.Gateway
+}
+
+// terminalFunctions are the chaincode functions a pos-terminal submits.
+var terminalFunctions = map[string]bool{
+	"RecordTransaction":       true,
+	"RecordTransactionsBatch": true,
+}
+
+// adminFunctions are the chaincode functions only an admin may submit.
+var adminFunctions = map[string]bool{
+	"AddGroupLocation":       true,
+	"CreateRestaurantGroup":  true,
+	"IndexRestaurantRecords": true,
+	"MigrateFlatRecords":     true,
+	"OffboardRestaurant":     true,
+	"RecomputeBalance":       true,
+	"RegisterRestaurant":     true,
+	"ReinstateRestaurant":    true,
+	"RemoveGroupLocation":    true,
+	"SetCommissionPlan":      true,
+	"SetRestaurantReserve":   true,
+	"SuspendRestaurant":      true,
+	"UpdateRestaurant":       true,
+	"UpdateTransaction":      true,
+	"VoidTransaction":        true,
+}
+
+// gatewayFor returns the gateway signing as the identity whose role may
+// submit function. Everything else, payouts and reads included, is submitted
+// as the finance user.
+func (setup *OrgSetup) gatewayFor(function string) *client.Gateway {
+	switch {
+	case terminalFunctions[function]:
+		return &setup.TerminalGateway
+	case adminFunctions[function]:
+		return &setup.AdminGateway
+	default:
+		return &setup.Gateway
+	}
 }
 
 func Serve(setups OrgSetup) {
@@ -31,6 +79,9 @@ func Serve(setups OrgSetup) {
 	http.HandleFunc("/block", setups.GetBlockByNumber)
 	http.HandleFunc("/history", setups.GetHistory)
 	http.HandleFunc("/search", setups.UniversalSearch)
+	http.HandleFunc("/records", setups.ListRecords)
+	http.HandleFunc("/payout/generate", setups.GeneratePayout)
+	http.HandleFunc("/transactions/batch", setups.RecordTransactionsBatch)
 
 	fmt.Println("Listening (http://localhost:3000/)...")
 	if err := http.ListenAndServe(":3000", nil); err != nil {
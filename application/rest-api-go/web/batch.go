@@ -0,0 +1,64 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// maxBatchBody bounds the request body of a batch upload.
+const maxBatchBody = 1 << 20
+
+// RecordTransactionsBatch records the JSON array of sales in the POST body in
+// a single transaction. mode is "atomic" (the default), which rejects the
+// whole batch if any sale is invalid, or "best-effort", which records the
+// valid sales and reports the rest. idempotent=true lets a terminal resend a
+// batch after a timeout. The response is the chaincode's per-sale result.
+func (setup *OrgSetup) RecordTransactionsBatch(w http.ResponseWriter, r *http.Request) {
+	setupCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "batches must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queryParams := r.URL.Query()
+	chainCodeName := queryParams.Get("chaincodeid")
+	channelID := queryParams.Get("channelid")
+	mode := queryParams.Get("mode")
+	if mode == "" {
+		mode = "atomic"
+	}
+	idempotent := queryParams.Get("idempotent") == "true"
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBody))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read batch: %s", err), http.StatusBadRequest)
+		return
+	}
+	var sales []json.RawMessage
+	if err := json.Unmarshal(body, &sales); err != nil {
+		http.Error(w, "batch must be a JSON array of transactions", http.StatusBadRequest)
+		return
+	}
+
+	network := setup.TerminalGateway.GetNetwork(channelID)
+	contract := network.GetContract(chainCodeName)
+
+	result, err := contract.Submit("RecordTransactionsBatch",
+		client.WithArguments(string(body), mode, fmt.Sprint(idempotent)),
+		client.WithEndorsingOrganizations(setup.MSPID),
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Blockchain Error: %s", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
@@ -0,0 +1,37 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+)
+
+// accessDeniedCode prefixes the chaincode's error when the caller's role may
+// not invoke a function. Gateway errors carry the chaincode message in their
+// details, which Error() includes.
+const accessDeniedCode = "ACCESS_DENIED"
+
+// batchRejectedCode prefixes the chaincode's error when an atomic batch of
+// sales holds invalid ones; the message lists each of them.
+const batchRejectedCode = "BATCH_REJECTED"
+
+// insufficientBalanceCode prefixes the chaincode's error when a payout would
+// take a restaurant's available balance below zero.
+const insufficientBalanceCode = "INSUFFICIENT_BALANCE"
+
+// invalidAmountCode prefixes the chaincode's error when a sale's amount is
+// not greater than zero.
+const invalidAmountCode = "INVALID_AMOUNT"
+
+// errorStatus picks the HTTP status for an error returned by the gateway.
+func errorStatus(err error) int {
+	if strings.Contains(err.Error(), accessDeniedCode) {
+		return http.StatusForbidden
+	}
+	if strings.Contains(err.Error(), batchRejectedCode) || strings.Contains(err.Error(), insufficientBalanceCode) {
+		return http.StatusUnprocessableEntity
+	}
+	if strings.Contains(err.Error(), invalidAmountCode) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -18,12 +18,19 @@ import (
 func Initialize(setup OrgSetup) (*OrgSetup, error) {
 	log.Printf("Initializing connection for %s...\n", setup.OrgName)
 	clientConnection := setup.newGrpcConnection()
-	id := setup.newIdentity()
-	sign := setup.newSign()
+	setup.Gateway = *setup.connect(clientConnection, setup.CertPath, setup.KeyPath)
+	setup.TerminalGateway = *setup.connect(clientConnection, setup.TerminalCertPath, setup.TerminalKeyPath)
+	setup.AdminGateway = *setup.connect(clientConnection, setup.AdminCertPath, setup.AdminKeyPath)
+	log.Println("Initialization complete")
+	return &setup, nil
+}
 
+// connect opens a gateway over clientConnection that signs as the identity
+// whose certificate is at certPath and private key in keyPath.
+func (setup OrgSetup) connect(clientConnection *grpc.ClientConn, certPath string, keyPath string) *client.Gateway {
 	gateway, err := client.Connect(
-		id,
-		client.WithSign(sign),
+		setup.newIdentity(certPath),
+		client.WithSign(setup.newSign(keyPath)),
 		client.WithHash(hash.SHA256),
 		client.WithClientConnection(clientConnection),
 		client.WithEvaluateTimeout(5*time.Second),
@@ -34,9 +41,7 @@ func Initialize(setup OrgSetup) (*OrgSetup, error) {
 	if err != nil {
 		panic(err)
 	}
-	setup.Gateway = *gateway
-	log.Println("Initialization complete")
-	return &setup, nil
+	return gateway
 }
 
 func (setup OrgSetup) newGrpcConnection() *grpc.ClientConn {
@@ -57,8 +62,8 @@ func (setup OrgSetup) newGrpcConnection() *grpc.ClientConn {
 	return connection
 }
 
-func (setup OrgSetup) newIdentity() *identity.X509Identity {
-	certificate, err := loadCertificate(setup.CertPath)
+func (setup OrgSetup) newIdentity(certPath string) *identity.X509Identity {
+	certificate, err := loadCertificate(certPath)
 	if err != nil {
 		panic(err)
 	}
@@ -71,12 +76,12 @@ func (setup OrgSetup) newIdentity() *identity.X509Identity {
 	return id
 }
 
-func (setup OrgSetup) newSign() identity.Sign {
-	files, err := os.ReadDir(setup.KeyPath)
+func (setup OrgSetup) newSign(keyPath string) identity.Sign {
+	files, err := os.ReadDir(keyPath)
 	if err != nil {
 		panic(fmt.Errorf("failed to read private key directory: %w", err))
 	}
-	privateKeyPEM, err := os.ReadFile(path.Join(setup.KeyPath, files[0].Name()))
+	privateKeyPEM, err := os.ReadFile(path.Join(keyPath, files[0].Name()))
 
 	if err != nil {
 		panic(fmt.Errorf("failed to read private key file: %w", err))
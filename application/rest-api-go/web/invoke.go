@@ -19,7 +19,7 @@ func (setup *OrgSetup) Invoke(w http.ResponseWriter, r *http.Request) {
 	args := r.Form["args"]
 	fmt.Printf("channel: %s, chaincode: %s, function: %s, args: %s\n", channelID, chainCodeName, function, args)
 
-	network := setup.Gateway.GetNetwork(channelID)
+	network := setup.gatewayFor(function).GetNetwork(channelID)
 	contract := network.GetContract(chainCodeName)
 
 	txn_proposal, err := contract.NewProposal(function,
@@ -28,12 +28,12 @@ func (setup *OrgSetup) Invoke(w http.ResponseWriter, r *http.Request) {
 	)
 	txn_endorsed, err := txn_proposal.Endorse()
 	if err != nil {
-		fmt.Fprintf(w, "Error endorsing txn: %s", err)
+		http.Error(w, fmt.Sprintf("Error endorsing txn: %s", err), errorStatus(err))
 		return
 	}
 	txn_committed, err := txn_endorsed.Submit()
 	if err != nil {
-		fmt.Fprintf(w, "Error submitting transaction: %s", err)
+		http.Error(w, fmt.Sprintf("Error submitting transaction: %s", err), errorStatus(err))
 		return
 	}
 	w.Write([]byte(txn_committed.TransactionID()))
@@ -0,0 +1,55 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// GeneratePayout creates a payout for a restaurant and period, or with
+// groupid for every location of a restaurant group. With dryrun=true the
+// payout is only previewed and nothing is written.
+func (setup *OrgSetup) GeneratePayout(w http.ResponseWriter, r *http.Request) {
+	setupCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("ParseForm() err: %s", err), http.StatusBadRequest)
+		return
+	}
+	chainCodeName := r.FormValue("chaincodeid")
+	channelID := r.FormValue("channelid")
+	restaurantID := r.FormValue("restaurantid")
+	groupID := r.FormValue("groupid")
+	periodStart := r.FormValue("periodstart")
+	periodEnd := r.FormValue("periodend")
+	dryRun := r.FormValue("dryrun") == "true"
+
+	network := setup.Gateway.GetNetwork(channelID)
+	contract := network.GetContract(chainCodeName)
+
+	previewFunction, generateFunction, payee := "PreviewPayout", "GeneratePayout", restaurantID
+	if groupID != "" {
+		previewFunction, generateFunction, payee = "PreviewGroupPayout", "GenerateGroupPayout", groupID
+	}
+
+	var result []byte
+	var err error
+	if dryRun {
+		result, err = contract.EvaluateTransaction(previewFunction, payee, periodStart, periodEnd)
+	} else {
+		result, err = contract.Submit(generateFunction,
+			client.WithArguments(payee, periodStart, periodEnd),
+			client.WithEndorsingOrganizations(setup.MSPID),
+		)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Blockchain Error: %s", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
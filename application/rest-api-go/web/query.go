@@ -36,7 +36,7 @@ func (setup OrgSetup) Query(w http.ResponseWriter, r *http.Request) {
 
 	evaluateResponse, err := contract.EvaluateTransaction(function, args...)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Blockchain Error: %s", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Blockchain Error: %s", err), errorStatus(err))
 		return
 	}
 	w.Write(evaluateResponse)
@@ -57,7 +57,7 @@ func (setup OrgSetup) GetHistory(w http.ResponseWriter, r *http.Request) {
 
 	res, err := contract.EvaluateTransaction("GetHistory", id)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("History Error: %s", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("History Error: %s", err), errorStatus(err))
 		return
 	}
 
@@ -122,6 +122,13 @@ func (setup OrgSetup) UniversalSearch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	res, err := poscc.EvaluateTransaction("GetRecord", input)
+	if err != nil {
+		res, err = poscc.EvaluateTransaction("GetTransactionByStripeID", input)
+	}
+	if err != nil && errorStatus(err) == http.StatusForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Record not found", http.StatusNotFound)
 		return
@@ -0,0 +1,78 @@
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const defaultPageSize = 50
+
+// recordPage is a page of records as returned by the chaincode's paginated
+// queries.
+type recordPage struct {
+	Records      json.RawMessage `json:"records"`
+	Bookmark     string          `json:"bookmark"`
+	FetchedCount int32           `json:"fetched_count"`
+}
+
+// ListRecords returns a page of ledger records. type limits the records to
+// one namespace (txn, payout, refund, restaurant, group, reserve, dispute,
+// adjustment), limit sets the page size and cursor is the next_cursor of the
+// previous page. With metadata=true the records carry their key and type.
+// next_cursor is empty on the last page.
+func (setup OrgSetup) ListRecords(w http.ResponseWriter, r *http.Request) {
+	setupCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	queryParams := r.URL.Query()
+	chainCodeName := queryParams.Get("chaincodeid")
+	channelID := queryParams.Get("channelid")
+	objectType := queryParams.Get("type")
+
+	pageSize := defaultPageSize
+	if limit := queryParams.Get("limit"); limit != "" {
+		var err error
+		pageSize, err = strconv.Atoi(limit)
+		if err != nil {
+			http.Error(w, "limit must be a number", http.StatusBadRequest)
+			return
+		}
+	}
+	bookmark, err := base64.RawURLEncoding.DecodeString(queryParams.Get("cursor"))
+	if err != nil {
+		http.Error(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+
+	function := "GetAllRecordsPage"
+	if queryParams.Get("metadata") == "true" {
+		function = "GetRecordsWithMetadataPage"
+	}
+
+	network := setup.Gateway.GetNetwork(channelID)
+	contract := network.GetContract(chainCodeName)
+
+	res, err := contract.EvaluateTransaction(function, objectType, strconv.Itoa(pageSize), string(bookmark))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Blockchain Error: %s", err), errorStatus(err))
+		return
+	}
+
+	var page recordPage
+	if err := json.Unmarshal(res, &page); err != nil {
+		http.Error(w, "Failed to decode records", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"records":     page.Records,
+		"next_cursor": base64.RawURLEncoding.EncodeToString([]byte(page.Bookmark)),
+		"count":       page.FetchedCount,
+	})
+}
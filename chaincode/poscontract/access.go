@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// roleAttribute is the X.509 attribute, issued by the Fabric CA at
+// enrolment, that carries a caller's role.
+const roleAttribute = "pos.role"
+
+const (
+	rolePOSTerminal = "pos-terminal"
+	roleFinance     = "finance"
+	roleAuditor     = "auditor"
+	roleAdmin       = "admin"
+)
+
+// knownRoles is checked in order against the certificate's OUs when the
+// caller has no role attribute, so identities from cryptogen (whose admins
+// carry OU=admin) and CA identities registered with a role OU both resolve.
+var knownRoles = []string{roleAdmin, roleFinance, roleAuditor, rolePOSTerminal}
+
+var readRoles = []string{roleFinance, roleAuditor}
+
+// functionRoles lists the roles, besides admin, that may call each contract
+// function. Admin may call anything; functions missing here are admin-only.
+var functionRoles = map[string][]string{
+	"RecordTransaction":       {rolePOSTerminal},
+	"RecordTransactionsBatch": {rolePOSTerminal},
+
+	"CreatePayout":          {roleFinance},
+	"GeneratePayout":        {roleFinance},
+	"PreviewPayout":         {roleFinance},
+	"GenerateGroupPayout":   {roleFinance},
+	"PreviewGroupPayout":    {roleFinance},
+	"UpdatePayoutStatus":    {roleFinance},
+	"RecordRefund":          {roleFinance},
+	"OpenDispute":           {roleFinance},
+	"SubmitDisputeEvidence": {roleFinance},
+	"ResolveDispute":        {roleFinance},
+	"ReleaseReserves":       {roleFinance},
+
+	"GetTransaction":             readRoles,
+	"GetTransactionByStripeID":   readRoles,
+	"GetPayout":                  readRoles,
+	"GetRefund":                  readRoles,
+	"GetDispute":                 readRoles,
+	"GetAdjustment":              readRoles,
+	"GetClawback":                readRoles,
+	"GetRestaurant":              readRoles,
+	"GetRestaurantBalance":       readRoles,
+	"GetRestaurantReserves":      readRoles,
+	"GetRestaurantGroup":         readRoles,
+	"ListRestaurantGroups":       readRoles,
+	"GetGroupBalance":            readRoles,
+	"GetGroupHistory":            readRoles,
+	"GetCommissionPlan":          readRoles,
+	"ListTransactions":           readRoles,
+	"ListPayouts":                readRoles,
+	"ListRefunds":                readRoles,
+	"ListDisputes":               readRoles,
+	"ListAdjustments":            readRoles,
+	"ListClawbacks":              readRoles,
+	"ListRestaurants":            readRoles,
+	"ListCommissionPlans":        readRoles,
+	"QueryTransactions":          readRoles,
+	"GetTrialBalance":            readRoles,
+	"GetAccountStatement":        readRoles,
+	"GetRecord":                  readRoles,
+	"GetAllRecords":              readRoles,
+	"GetAllRecordsPage":          readRoles,
+	"GetRecordsWithMetadata":     readRoles,
+	"GetRecordsWithMetadataPage": readRoles,
+	"GetHistory":                 readRoles,
+}
+
+// callerRole returns the caller's role from its role attribute or, failing
+// that, from an OU naming one of the known roles. It returns "" if neither
+// is present.
+func callerRole(ctx contractapi.TransactionContextInterface) (string, error) {
+	identity := ctx.GetClientIdentity()
+	role, found, err := identity.GetAttributeValue(roleAttribute)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return role, nil
+	}
+
+	cert, err := identity.GetX509Certificate()
+	if err != nil {
+		return "", err
+	}
+	for _, known := range knownRoles {
+		for _, ou := range cert.Subject.OrganizationalUnit {
+			if ou == known {
+				return known, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// authorizeCaller runs before every contract function and rejects callers
+// whose role is not allowed to invoke it.
+func authorizeCaller(ctx contractapi.TransactionContextInterface) error {
+	function := contractFunctionName(ctx)
+	role, err := callerRole(ctx)
+	if err != nil {
+		return &AccessDeniedError{Function: function, Reason: err.Error()}
+	}
+	if role == "" {
+		return &AccessDeniedError{Function: function, Reason: "caller has no role"}
+	}
+	if role == roleAdmin {
+		return nil
+	}
+	for _, allowed := range functionRoles[function] {
+		if role == allowed {
+			return nil
+		}
+	}
+	return &AccessDeniedError{Function: function, Role: role}
+}
+
+// contractFunctionName returns the invoked function without any contract
+// namespace, capitalised the way contractapi resolves it.
+func contractFunctionName(ctx contractapi.TransactionContextInterface) string {
+	name, _ := ctx.GetStub().GetFunctionAndParameters()
+	name = name[strings.LastIndex(name, ":")+1:]
+	if name == "" {
+		return name
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
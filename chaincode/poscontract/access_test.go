@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCallsOutsideTheCallersRoleAreDenied(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "20.00", "GBP", "ch_1", "", "", "false")
+
+	for _, call := range []struct {
+		role     string
+		function string
+		args     []string
+	}{
+		{rolePOSTerminal, "CreatePayout", []string{"P1", "R1", "20.00", "GBP", `["T1"]`, "false"}},
+		{rolePOSTerminal, "createPayout", []string{"P1", "R1", "20.00", "GBP", `["T1"]`, "false"}},
+		{rolePOSTerminal, "GetTransaction", []string{"T1"}},
+		{roleAuditor, "RecordTransaction", []string{"T2", "R1", "20.00", "GBP", "ch_2", "", "", "false"}},
+		{roleAuditor, "RecordRefund", []string{"RF1", "T1", "5.00", "re_1", "returned"}},
+		{roleFinance, "RecordTransaction", []string{"T2", "R1", "20.00", "GBP", "ch_2", "", "", "false"}},
+		{roleFinance, "RegisterRestaurant", []string{"R2", "Bistro", "Bistro Ltd", "GBP", ""}},
+		{roleFinance, "VoidTransaction", []string{"T1", "entered twice"}},
+		{"nobody", "GetTransaction", []string{"T1"}},
+	} {
+		_, err := n.submit(call.role, call.function, call.args...)
+		if err == nil || !strings.Contains(err.Error(), codeAccessDenied) {
+			t.Errorf("%s calling %s: got %v, want %s", call.role, call.function, err, codeAccessDenied)
+		}
+	}
+
+	for _, role := range []string{roleFinance, roleAuditor, roleAdmin} {
+		n.mustSubmit(role, "GetTransaction", "T1")
+	}
+	n.mustSubmit(roleAdmin, "RecordTransaction", "T2", "R1", "20.00", "GBP", "ch_2", "", "", "false")
+	if tx := decode[Transaction](t, n.mustSubmit(roleAuditor, "GetTransaction", "T1")); tx.PayoutID != "" || tx.Status == txStatusVoided {
+		t.Errorf("denied calls changed T1 to %s in payout %q", tx.Status, tx.PayoutID)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const adjustmentObjectType = "adjustment"
+
+// Kinds of adjustment. A carry_forward adjustment moves a negative share of
+// one payout into the next: the restaurant's balance already owes it, so it
+// changes neither the balance nor the journal.
+const (
+	adjustmentKindChargeback   = "chargeback"
+	adjustmentKindCarryForward = "carry_forward"
+)
+
+// Adjustment is a deduction from what a restaurant is owed that is not a
+// refund, such as a chargeback lost in a dispute or a negative balance
+// carried forward. Reference is the id of the record that caused it. Like
+// refunds, adjustments are deducted from the restaurant's next payout.
+type Adjustment struct {
+	ID            string `json:"id"`
+	Kind          string `json:"kind"`
+	RestaurantID  string `json:"restaurant_id"`
+	TransactionID string `json:"transaction_id,omitempty" metadata:",optional"`
+	Reference     string `json:"reference"`
+	Amount        Money  `json:"amount"`
+	Reason        string `json:"reason"`
+	Timestamp     string `json:"timestamp"`
+	PayoutID      string `json:"payout_id,omitempty" metadata:",optional"`
+}
+
+func (s *SmartContract) GetAdjustment(ctx contractapi.TransactionContextInterface, id string) (*Adjustment, error) {
+	adjustment, err := findAdjustment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if adjustment == nil {
+		return nil, fmt.Errorf("adjustment %s not found", id)
+	}
+	return adjustment, nil
+}
+
+func (s *SmartContract) ListAdjustments(ctx contractapi.TransactionContextInterface) ([]*Adjustment, error) {
+	return listRecords[Adjustment](ctx, adjustmentObjectType)
+}
+
+// recordAdjustment writes a new adjustment and applies it to the
+// restaurant's balance and the journal.
+func recordAdjustment(ctx contractapi.TransactionContextInterface, adjustment *Adjustment) error {
+	existing, err := findAdjustment(ctx, adjustment.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return &AlreadyExistsError{Kind: "adjustment", ID: adjustment.ID}
+	}
+	adjustment.Timestamp, err = txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := putAdjustment(ctx, adjustment); err != nil {
+		return err
+	}
+	if err := indexRestaurantRecord(ctx, adjustmentObjectType, adjustment.RestaurantID, adjustment.Timestamp, adjustment.ID); err != nil {
+		return err
+	}
+	if adjustment.Kind == adjustmentKindCarryForward {
+		return nil
+	}
+	if err := changeBalance(ctx, adjustment.RestaurantID, adjustment.Amount.Currency, adjustmentBalanceChange(adjustment)); err != nil {
+		return err
+	}
+	return postAdjustment(ctx, adjustment)
+}
+
+// outstandingAdjustments returns the adjustments of restaurantID that no
+// payout has deducted yet, limited to those recorded before cutoff unless it
+// is zero. Balances carried forward are always included, since the payout
+// that carried them has already been made.
+func outstandingAdjustments(ctx contractapi.TransactionContextInterface, restaurantID string, cutoff time.Time) ([]*Adjustment, error) {
+	adjustments, err := listRestaurantRecords[Adjustment](ctx, adjustmentObjectType, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var outstanding []*Adjustment
+	for _, adjustment := range adjustments {
+		if adjustment.PayoutID != "" {
+			continue
+		}
+		if !cutoff.IsZero() && adjustment.Kind != adjustmentKindCarryForward {
+			recorded, err := time.Parse(time.RFC3339, adjustment.Timestamp)
+			if err != nil {
+				return nil, fmt.Errorf("adjustment %s has an unreadable timestamp %q", adjustment.ID, adjustment.Timestamp)
+			}
+			if !recorded.Before(cutoff) {
+				continue
+			}
+		}
+		outstanding = append(outstanding, adjustment)
+	}
+	return outstanding, nil
+}
+
+// carryForward brings the share of each restaurant the payout would pay a
+// negative amount up to zero with a carry_forward line, and sets the
+// adjustments that deduct the shortfall from the restaurant's next payout.
+func (items *payoutItems) carryForward(payout *Payout, total *Money) error {
+	items.carried = nil
+	shares := map[string]int64{}
+	for _, line := range payout.Lines {
+		shares[payout.lineRestaurant(line)] += line.Amount.MinorUnits
+	}
+	var restaurantIDs []string
+	for restaurantID, share := range shares {
+		if share < 0 {
+			restaurantIDs = append(restaurantIDs, restaurantID)
+		}
+	}
+	sort.Strings(restaurantIDs)
+
+	for _, restaurantID := range restaurantIDs {
+		adjustment := &Adjustment{
+			ID:           fmt.Sprintf("carry-forward-%s-%s", payout.ID, restaurantID),
+			Kind:         adjustmentKindCarryForward,
+			RestaurantID: restaurantID,
+			Reference:    payout.ID,
+			Amount:       Money{MinorUnits: -shares[restaurantID], Currency: total.Currency},
+			Reason:       fmt.Sprintf("negative balance carried forward from payout %s", payout.ID),
+		}
+		if err := payout.addLine(total, payoutLineCarryForward, adjustment.ID, restaurantID, adjustment.Amount); err != nil {
+			return err
+		}
+		items.carried = append(items.carried, adjustment)
+	}
+	return nil
+}
+
+// dropCarriedForward deletes the balances a cancelled payout carried forward,
+// which its released deductions will carry again. It fails if a later payout
+// has already deducted one of them; that payout must be cancelled first.
+func dropCarriedForward(ctx contractapi.TransactionContextInterface, payout *Payout) error {
+	for _, line := range payout.Lines {
+		if line.Kind != payoutLineCarryForward {
+			continue
+		}
+		adjustment, err := findAdjustment(ctx, line.RecordID)
+		if err != nil {
+			return err
+		}
+		if adjustment == nil {
+			continue
+		}
+		if adjustment.PayoutID != "" {
+			return fmt.Errorf("the balance payout %s carried forward has been deducted by payout %s, which must be cancelled first", payout.ID, adjustment.PayoutID)
+		}
+		if err := deleteRecord(ctx, adjustmentObjectType, adjustment.ID); err != nil {
+			return err
+		}
+		if err := unindexRestaurantRecord(ctx, adjustmentObjectType, adjustment.RestaurantID, adjustment.Timestamp, adjustment.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findAdjustment returns nil, nil when id is not on the ledger.
+func findAdjustment(ctx contractapi.TransactionContextInterface, id string) (*Adjustment, error) {
+	return findRecord[Adjustment](ctx, adjustmentObjectType, id)
+}
+
+func putAdjustment(ctx contractapi.TransactionContextInterface, adjustment *Adjustment) error {
+	return putRecord(ctx, adjustmentObjectType, adjustment.ID, adjustment)
+}
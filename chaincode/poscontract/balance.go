@@ -0,0 +1,531 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	balanceObjectType      = "balance"
+	balanceDeltaObjectType = "balance_delta"
+)
+
+// RestaurantBalance is what the network owes a restaurant, kept up to date as
+// its sales, refunds and payouts are recorded. Available is owed but not yet
+// in a payout, Reserved is withheld from payouts under the restaurant's
+// rolling reserve, Pending is in payouts that have not been paid and PaidOut
+// has been paid. Available is negative while the restaurant owes more than
+// it is owed; ClawedBack is what it was asked to repay when it was
+// offboarded. GrossSales, Fees, Refunds and Adjustments are the running
+// totals behind them: Available + Reserved + Pending + PaidOut - ClawedBack
+// always equals GrossSales - Fees - Refunds - Adjustments.
+//
+// So that concurrent sales of a restaurant do not conflict with each other,
+// each transaction writes its change to the balance under its own delta key
+// instead of rewriting one balance key. Reading the balance adds the deltas
+// to the stored balance, and new payouts and RecomputeBalance fold them into
+// it. That read ranges over the restaurant's delta keys, and Fabric checks
+// the range again at commit, so a transaction that reads the balance -
+// creating or generating a payout, which checks it for INSUFFICIENT_BALANCE,
+// offboarding or RecomputeBalance - fails with PHANTOM_READ_CONFLICT if a
+// sale, refund or adjustment of that restaurant commits first, and has to be
+// submitted again.
+type RestaurantBalance struct {
+	RestaurantID string `json:"restaurant_id"`
+	Available    Money  `json:"available"`
+	Reserved     Money  `json:"reserved"`
+	Pending      Money  `json:"pending"`
+	PaidOut      Money  `json:"paid_out"`
+	GrossSales   Money  `json:"gross_sales"`
+	Fees         Money  `json:"fees"`
+	Refunds      Money  `json:"refunds"`
+	Adjustments  Money  `json:"adjustments"`
+	ClawedBack   Money  `json:"clawed_back"`
+	UpdatedAt    string `json:"updated_at,omitempty" metadata:",optional"`
+}
+
+// BalanceRecomputation is the result of RecomputeBalance. Stored is the
+// balance as it was kept, or nil if there was none, and Drifted reports
+// whether it differed from the balance rebuilt from the records.
+type BalanceRecomputation struct {
+	Stored     *RestaurantBalance `json:"stored,omitempty" metadata:",optional"`
+	Recomputed *RestaurantBalance `json:"recomputed"`
+	Drifted    bool               `json:"drifted"`
+}
+
+// balanceChange adjusts the totals of a balance, in minor units of the
+// restaurant's currency.
+type balanceChange struct {
+	available   int64
+	reserved    int64
+	pending     int64
+	paidOut     int64
+	grossSales  int64
+	fees        int64
+	refunds     int64
+	adjustments int64
+	clawedBack  int64
+}
+
+// GetRestaurantBalance returns the balance of a registered restaurant. A
+// restaurant with nothing recorded yet has a zero balance.
+func (s *SmartContract) GetRestaurantBalance(ctx contractapi.TransactionContextInterface, restaurantID string) (*RestaurantBalance, error) {
+	restaurant, err := readRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := findBalance(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	if balance == nil {
+		balance = newBalance(restaurantID, restaurant.Currency)
+	}
+	return balance, nil
+}
+
+// RecomputeBalance rebuilds a restaurant's balance from its sales, refunds,
+// adjustments, payouts, reserves and clawback, stores it and reports whether
+// the kept balance had drifted. It is also how balances are first built for
+// restaurants whose records predate them, once IndexRestaurantRecords has
+// indexed those records.
+func (s *SmartContract) RecomputeBalance(ctx contractapi.TransactionContextInterface, restaurantID string) (*BalanceRecomputation, error) {
+	restaurant, err := readRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	stored, deltaKeys, err := readBalance(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var change balanceChange
+	transactions, err := listRestaurantRecords[Transaction](ctx, transactionObjectType, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range transactions {
+		if tx.Status == txStatusVoided {
+			continue
+		}
+		if err := checkBalanceCurrency(restaurant, "transaction", tx.ID, tx.Amount); err != nil {
+			return nil, err
+		}
+		change = change.plus(saleBalanceChange(tx))
+	}
+
+	refunds, err := listRestaurantRecords[Refund](ctx, refundObjectType, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	for _, refund := range refunds {
+		if err := checkBalanceCurrency(restaurant, "refund", refund.ID, refund.Amount); err != nil {
+			return nil, err
+		}
+		change = change.plus(refundBalanceChange(refund))
+	}
+
+	adjustments, err := listRestaurantRecords[Adjustment](ctx, adjustmentObjectType, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	for _, adjustment := range adjustments {
+		if adjustment.Kind == adjustmentKindCarryForward {
+			continue
+		}
+		if err := checkBalanceCurrency(restaurant, "adjustment", adjustment.ID, adjustment.Amount); err != nil {
+			return nil, err
+		}
+		change = change.plus(adjustmentBalanceChange(adjustment))
+	}
+
+	payouts, err := listRecords[Payout](ctx, payoutObjectType)
+	if err != nil {
+		return nil, err
+	}
+	for _, payout := range payouts {
+		if payout.Status == payoutStatusCancelled {
+			continue
+		}
+		for _, share := range payout.restaurantShares() {
+			if share.RestaurantID != restaurantID {
+				continue
+			}
+			if err := checkBalanceCurrency(restaurant, "payout", payout.ID, share.Amount); err != nil {
+				return nil, err
+			}
+			change = change.plus(payoutBalanceChange(share.Amount))
+			if payout.Status == payoutStatusPaid {
+				change = change.plus(payoutStatusBalanceChange(share.Amount, payoutStatusPaid))
+			}
+		}
+	}
+
+	clawback, err := findClawback(ctx, clawbackID(restaurantID))
+	if err != nil {
+		return nil, err
+	}
+	if clawback != nil {
+		if err := checkBalanceCurrency(restaurant, "clawback", clawback.ID, clawback.Amount); err != nil {
+			return nil, err
+		}
+		change = change.plus(clawbackBalanceChange(clawback))
+	}
+
+	reserves, err := listRestaurantRecords[Reserve](ctx, reserveObjectType, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	for _, reserve := range reserves {
+		if reserve.Status != reserveStatusHeld {
+			continue
+		}
+		if err := checkBalanceCurrency(restaurant, "reserve", reserve.ID, reserve.Amount); err != nil {
+			return nil, err
+		}
+		change = change.plus(reserveBalanceChange(reserve.Amount))
+	}
+
+	recomputed := newBalance(restaurantID, restaurant.Currency)
+	change.apply(recomputed)
+	recomputed.UpdatedAt, err = txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := storeBalance(ctx, recomputed, deltaKeys); err != nil {
+		return nil, err
+	}
+	return &BalanceRecomputation{
+		Stored:     stored,
+		Recomputed: recomputed,
+		Drifted:    stored == nil || !stored.sameTotals(recomputed),
+	}, nil
+}
+
+// changeBalance records change to the balance of restaurantID, in currency,
+// as this transaction's delta. It reads only the delta key, which no other
+// transaction writes. Fabric does not let a transaction read its own writes,
+// so a contract function must call it at most once per restaurant, combining
+// its changes with plus; a batch, whose stub caches its writes, may call it
+// once per sale.
+func changeBalance(ctx contractapi.TransactionContextInterface, restaurantID string, currency string, change balanceChange) error {
+	if change == (balanceChange{}) {
+		return nil
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(balanceDeltaObjectType, []string{restaurantID, ctx.GetStub().GetTxID()})
+	if err != nil {
+		return err
+	}
+	deltaBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	delta := newBalance(restaurantID, currency)
+	if deltaBytes != nil {
+		if err := json.Unmarshal(deltaBytes, delta); err != nil {
+			return fmt.Errorf("failed to decode %s: %v", key, err)
+		}
+	}
+	if delta.Available.Currency != currency {
+		return fmt.Errorf("balance of restaurant %s is in %s, not %s", restaurantID, delta.Available.Currency, currency)
+	}
+	change.apply(delta)
+	delta.UpdatedAt, err = txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	deltaBytes, err = json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, deltaBytes)
+}
+
+// saleBalanceChange is the change a sale makes: its net amount becomes
+// available and its fees are taken. Voiding or correcting a sale applies the
+// negation of its change.
+func saleBalanceChange(tx *Transaction) balanceChange {
+	change := balanceChange{
+		available:  tx.net().MinorUnits,
+		grossSales: tx.Amount.MinorUnits,
+	}
+	if tx.ProcessorFee != nil {
+		change.fees += tx.ProcessorFee.MinorUnits
+	}
+	if tx.Commission != nil {
+		change.fees += tx.Commission.MinorUnits
+	}
+	return change
+}
+
+// moveSaleBalance replaces the change previous made with the change of the
+// corrected sale tx, on one balance or, if the sale moved restaurant, two.
+func moveSaleBalance(ctx contractapi.TransactionContextInterface, previous *Transaction, tx *Transaction) error {
+	if previous.RestaurantID == tx.RestaurantID {
+		change := saleBalanceChange(tx).plus(saleBalanceChange(previous).negate())
+		return changeBalance(ctx, tx.RestaurantID, tx.Amount.Currency, change)
+	}
+	if err := changeBalance(ctx, previous.RestaurantID, previous.Amount.Currency, saleBalanceChange(previous).negate()); err != nil {
+		return err
+	}
+	return changeBalance(ctx, tx.RestaurantID, tx.Amount.Currency, saleBalanceChange(tx))
+}
+
+func refundBalanceChange(refund *Refund) balanceChange {
+	return balanceChange{available: -refund.Amount.MinorUnits, refunds: refund.Amount.MinorUnits}
+}
+
+func adjustmentBalanceChange(adjustment *Adjustment) balanceChange {
+	return balanceChange{available: -adjustment.Amount.MinorUnits, adjustments: adjustment.Amount.MinorUnits}
+}
+
+// clawbackBalanceChange settles a restaurant's negative balance by what it is
+// asked to repay.
+func clawbackBalanceChange(clawback *Clawback) balanceChange {
+	return balanceChange{available: clawback.Amount.MinorUnits, clawedBack: clawback.Amount.MinorUnits}
+}
+
+// changePayoutBalances applies change to the balance of every restaurant
+// the payout pays, for its share of the payout.
+func changePayoutBalances(ctx contractapi.TransactionContextInterface, payout *Payout, change func(share PayoutLocation) balanceChange) error {
+	for _, share := range payout.restaurantShares() {
+		if err := changeBalance(ctx, share.RestaurantID, share.Amount.Currency, change(share)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// payoutBalanceChange moves a restaurant's share of a new payout from
+// available to pending.
+func payoutBalanceChange(share Money) balanceChange {
+	return balanceChange{available: -share.MinorUnits, pending: share.MinorUnits}
+}
+
+// applyNewPayoutBalances applies a new payout to the balances of the
+// restaurants it pays. It refuses a payout that would take more from a
+// restaurant's available balance than there is, leaving it below zero.
+// Having read each balance in full, it stores it with the payout applied in
+// place of its deltas.
+func applyNewPayoutBalances(ctx contractapi.TransactionContextInterface, payout *Payout) error {
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	for _, share := range payout.restaurantShares() {
+		change := payout.newPayoutBalanceChange(share)
+		balance, deltaKeys, err := readBalance(ctx, share.RestaurantID)
+		if err != nil {
+			return err
+		}
+		if balance == nil {
+			balance = newBalance(share.RestaurantID, share.Amount.Currency)
+		}
+		if change.available < 0 && balance.Available.MinorUnits+change.available < 0 {
+			return &InsufficientBalanceError{
+				RestaurantID: share.RestaurantID,
+				Available:    balance.Available,
+				Amount:       Money{MinorUnits: -change.available, Currency: share.Amount.Currency},
+			}
+		}
+		if balance.Available.Currency != share.Amount.Currency {
+			return fmt.Errorf("balance of restaurant %s is in %s, not %s", share.RestaurantID, balance.Available.Currency, share.Amount.Currency)
+		}
+		change.apply(balance)
+		balance.UpdatedAt = timestamp
+		if err := storeBalance(ctx, balance, deltaKeys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newPayoutBalanceChange is the change a new payout makes to a restaurant it
+// pays: its share moves to pending and the reserve withheld from it to
+// reserved.
+func (payout *Payout) newPayoutBalanceChange(share PayoutLocation) balanceChange {
+	return payoutBalanceChange(share.Amount).plus(reserveBalanceChange(payout.withheld(share.RestaurantID)))
+}
+
+// reserveBalanceChange moves a reserve from available to reserved. Releasing
+// or cancelling the reserve applies its negation.
+func reserveBalanceChange(amount Money) balanceChange {
+	return balanceChange{available: -amount.MinorUnits, reserved: amount.MinorUnits}
+}
+
+// payoutStatusBalanceChange is the change a payout moving to status makes to
+// a restaurant's share: paying it moves the share from pending to paid out
+// and cancelling it returns the share to available. Other statuses leave the
+// balance alone.
+func payoutStatusBalanceChange(share Money, status string) balanceChange {
+	switch status {
+	case payoutStatusPaid:
+		return balanceChange{pending: -share.MinorUnits, paidOut: share.MinorUnits}
+	case payoutStatusCancelled:
+		return balanceChange{pending: -share.MinorUnits, available: share.MinorUnits}
+	}
+	return balanceChange{}
+}
+
+func (c balanceChange) plus(other balanceChange) balanceChange {
+	return balanceChange{
+		available:   c.available + other.available,
+		reserved:    c.reserved + other.reserved,
+		pending:     c.pending + other.pending,
+		paidOut:     c.paidOut + other.paidOut,
+		grossSales:  c.grossSales + other.grossSales,
+		fees:        c.fees + other.fees,
+		refunds:     c.refunds + other.refunds,
+		adjustments: c.adjustments + other.adjustments,
+		clawedBack:  c.clawedBack + other.clawedBack,
+	}
+}
+
+func (c balanceChange) negate() balanceChange {
+	return balanceChange{
+		available:   -c.available,
+		reserved:    -c.reserved,
+		pending:     -c.pending,
+		paidOut:     -c.paidOut,
+		grossSales:  -c.grossSales,
+		fees:        -c.fees,
+		refunds:     -c.refunds,
+		adjustments: -c.adjustments,
+		clawedBack:  -c.clawedBack,
+	}
+}
+
+// add adds the totals of delta to the balance.
+func (b *RestaurantBalance) add(delta *RestaurantBalance) {
+	b.Available.MinorUnits += delta.Available.MinorUnits
+	b.Reserved.MinorUnits += delta.Reserved.MinorUnits
+	b.Pending.MinorUnits += delta.Pending.MinorUnits
+	b.PaidOut.MinorUnits += delta.PaidOut.MinorUnits
+	b.GrossSales.MinorUnits += delta.GrossSales.MinorUnits
+	b.Fees.MinorUnits += delta.Fees.MinorUnits
+	b.Refunds.MinorUnits += delta.Refunds.MinorUnits
+	b.Adjustments.MinorUnits += delta.Adjustments.MinorUnits
+	b.ClawedBack.MinorUnits += delta.ClawedBack.MinorUnits
+	if delta.UpdatedAt > b.UpdatedAt {
+		b.UpdatedAt = delta.UpdatedAt
+	}
+}
+
+func (c balanceChange) apply(balance *RestaurantBalance) {
+	balance.Available.MinorUnits += c.available
+	balance.Reserved.MinorUnits += c.reserved
+	balance.Pending.MinorUnits += c.pending
+	balance.PaidOut.MinorUnits += c.paidOut
+	balance.GrossSales.MinorUnits += c.grossSales
+	balance.Fees.MinorUnits += c.fees
+	balance.Refunds.MinorUnits += c.refunds
+	balance.Adjustments.MinorUnits += c.adjustments
+	balance.ClawedBack.MinorUnits += c.clawedBack
+}
+
+func newBalance(restaurantID string, currency string) *RestaurantBalance {
+	zero := Money{Currency: currency}
+	return &RestaurantBalance{
+		RestaurantID: restaurantID,
+		Available:    zero,
+		Reserved:     zero,
+		Pending:      zero,
+		PaidOut:      zero,
+		GrossSales:   zero,
+		Fees:         zero,
+		Refunds:      zero,
+		Adjustments:  zero,
+		ClawedBack:   zero,
+	}
+}
+
+// sameTotals reports whether two balances hold the same amounts.
+func (b *RestaurantBalance) sameTotals(other *RestaurantBalance) bool {
+	return b.Available == other.Available &&
+		b.Reserved == other.Reserved &&
+		b.Pending == other.Pending &&
+		b.PaidOut == other.PaidOut &&
+		b.GrossSales == other.GrossSales &&
+		b.Fees == other.Fees &&
+		b.Refunds == other.Refunds &&
+		b.Adjustments == other.Adjustments &&
+		b.ClawedBack == other.ClawedBack
+}
+
+func checkBalanceCurrency(restaurant *Restaurant, kind string, id string, amount Money) error {
+	if amount.Currency != restaurant.Currency {
+		return fmt.Errorf("%s %s is in %s but restaurant %s trades in %s", kind, id, amount.Currency, restaurant.ID, restaurant.Currency)
+	}
+	return nil
+}
+
+// findBalance returns nil, nil when the restaurant has no balance yet.
+func findBalance(ctx contractapi.TransactionContextInterface, restaurantID string) (*RestaurantBalance, error) {
+	balance, _, err := readBalance(ctx, restaurantID)
+	return balance, err
+}
+
+// readBalance adds up the stored balance of restaurantID and its deltas,
+// returning the keys of the deltas so that the caller can fold them into the
+// stored balance with storeBalance. It returns a nil balance when the
+// restaurant has neither. The deltas are read as a range, so the calling
+// transaction conflicts with any that changes the restaurant's balance
+// before it commits.
+func readBalance(ctx contractapi.TransactionContextInterface, restaurantID string) (*RestaurantBalance, []string, error) {
+	balance, err := findRecord[RestaurantBalance](ctx, balanceObjectType, restaurantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if balance != nil {
+		// Balances kept before reserves, adjustments and clawbacks have no
+		// totals for them.
+		if balance.Reserved.Currency == "" {
+			balance.Reserved = Money{Currency: balance.Available.Currency}
+		}
+		if balance.Adjustments.Currency == "" {
+			balance.Adjustments = Money{Currency: balance.Available.Currency}
+		}
+		if balance.ClawedBack.Currency == "" {
+			balance.ClawedBack = Money{Currency: balance.Available.Currency}
+		}
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(balanceDeltaObjectType, []string{restaurantID})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+
+	var deltaKeys []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		var delta RestaurantBalance
+		if err := json.Unmarshal(queryResponse.Value, &delta); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode %s: %v", queryResponse.Key, err)
+		}
+		if balance == nil {
+			balance = newBalance(restaurantID, delta.Available.Currency)
+		}
+		if delta.Available.Currency != balance.Available.Currency {
+			return nil, nil, fmt.Errorf("balance of restaurant %s is in %s, but %s is in %s", restaurantID, balance.Available.Currency, queryResponse.Key, delta.Available.Currency)
+		}
+		balance.add(&delta)
+		deltaKeys = append(deltaKeys, queryResponse.Key)
+	}
+	return balance, deltaKeys, nil
+}
+
+// storeBalance stores balance in place of the deltas it was read with.
+func storeBalance(ctx contractapi.TransactionContextInterface, balance *RestaurantBalance, deltaKeys []string) error {
+	for _, key := range deltaKeys {
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return fmt.Errorf("failed to delete %s: %v", key, err)
+		}
+	}
+	return putRecord(ctx, balanceObjectType, balance.RestaurantID, balance)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkBalanceInvariant checks that the restaurant's balance accounts for
+// everything recorded against it.
+func checkBalanceInvariant(t *testing.T, n *testNetwork, restaurantID string) *RestaurantBalance {
+	t.Helper()
+	balance := decode[RestaurantBalance](t, n.mustSubmit(roleFinance, "GetRestaurantBalance", restaurantID))
+	held := balance.Available.MinorUnits + balance.Reserved.MinorUnits + balance.Pending.MinorUnits + balance.PaidOut.MinorUnits - balance.ClawedBack.MinorUnits
+	earned := balance.GrossSales.MinorUnits - balance.Fees.MinorUnits - balance.Refunds.MinorUnits - balance.Adjustments.MinorUnits
+	if held != earned {
+		t.Errorf("available %s + reserved %s + pending %s + paid out %s - clawed back %s = %d, but gross sales %s - fees %s - refunds %s - adjustments %s = %d",
+			balance.Available, balance.Reserved, balance.Pending, balance.PaidOut, balance.ClawedBack, held,
+			balance.GrossSales, balance.Fees, balance.Refunds, balance.Adjustments, earned)
+	}
+	return balance
+}
+
+// balanceKeys counts the stored balances and balance deltas.
+func balanceKeys(n *testNetwork) (balances int, deltas int) {
+	for key := range n.stub.State {
+		switch {
+		case strings.HasPrefix(key, "\x00"+balanceObjectType+"\x00"):
+			balances++
+		case strings.HasPrefix(key, "\x00"+balanceDeltaObjectType+"\x00"):
+			deltas++
+		}
+	}
+	return balances, deltas
+}
+
+func TestBalanceInvariantHolds(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "SetCommissionPlan", `{"id": "standard", "type": "percentage", "rate_bps": 1000, "processor_rate_bps": 150, "processor_fixed_fee": {"minor_units": 20, "currency": "GBP"}}`)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "standard")
+	n.mustSubmit(roleAdmin, "SetRestaurantReserve", "R1", "1000", "7")
+	checkBalanceInvariant(t, n, "R1")
+
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "100.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(rolePOSTerminal, "RecordTransactionsBatch", `[
+		{"id": "T2", "restaurant_id": "R1", "amount": "40.00", "currency": "GBP", "stripe_payment_id": "ch_2"},
+		{"id": "T3", "restaurant_id": "R1", "amount": "25.50", "currency": "GBP", "stripe_payment_id": "ch_3"},
+		{"id": "T4", "restaurant_id": "R1", "amount": "12.00", "currency": "GBP", "stripe_payment_id": "ch_4"}
+	]`, batchModeAtomic, "false")
+	if balances, deltas := balanceKeys(n); balances != 0 || deltas != 2 {
+		t.Errorf("sales wrote %d balances and %d deltas, want only a delta per transaction", balances, deltas)
+	}
+	balance := checkBalanceInvariant(t, n, "R1")
+	if balance.GrossSales.MinorUnits != 17750 {
+		t.Errorf("gross sales %s, want 177.50 GBP", balance.GrossSales)
+	}
+
+	n.mustSubmit(roleAdmin, "VoidTransaction", "T4", "entered twice")
+	n.mustSubmit(roleFinance, "RecordRefund", "RF1", "T2", "15.00", "re_1", "returned")
+	n.mustSubmit(roleFinance, "OpenDispute", "D1", "T3", "25.50", "dp_1", "fraudulent")
+	n.mustSubmit(roleFinance, "ResolveDispute", "D1", "lost", "no evidence")
+	checkBalanceInvariant(t, n, "R1")
+
+	payout := decode[Payout](t, n.mustSubmit(roleFinance, "GeneratePayout", "R1", "2025-01-01T00:00:00Z", "2026-01-01T00:00:00Z"))
+	if balances, deltas := balanceKeys(n); balances != 1 || deltas != 0 {
+		t.Errorf("payout left %d balances and %d deltas, want the deltas folded into one balance", balances, deltas)
+	}
+	checkBalanceInvariant(t, n, "R1")
+	markPaid(t, n, payout.ID)
+	n.advance(8 * 24 * time.Hour)
+	n.mustSubmit(roleFinance, "ReleaseReserves", "R1")
+	balance = checkBalanceInvariant(t, n, "R1")
+	if balance.Reserved.MinorUnits != 0 || balance.Pending.MinorUnits != 0 || balance.Available.MinorUnits <= 0 {
+		t.Errorf("available %s, reserved %s and pending %s, want only the released reserve available", balance.Available, balance.Reserved, balance.Pending)
+	}
+
+	recomputation := decode[BalanceRecomputation](t, n.mustSubmit(roleAdmin, "RecomputeBalance", "R1"))
+	if recomputation.Drifted {
+		t.Errorf("kept balance %+v drifted from the records' %+v", recomputation.Stored, recomputation.Recomputed)
+	}
+	if balances, deltas := balanceKeys(n); balances != 1 || deltas != 0 {
+		t.Errorf("recomputation left %d balances and %d deltas, want one balance", balances, deltas)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// Batch modes. An atomic batch is written only if every sale in it is valid;
+// a best-effort batch writes the valid sales and reports the rest.
+const (
+	batchModeAtomic     = "atomic"
+	batchModeBestEffort = "best-effort"
+)
+
+// maxBatchSize caps the sales in one batch so that a proposal stays well
+// within the peer's message size and endorsement timeout.
+const maxBatchSize = 500
+
+// Outcomes of a single sale in a batch.
+const (
+	batchItemRecorded  = "recorded"
+	batchItemDuplicate = "duplicate"
+	batchItemFailed    = "failed"
+)
+
+// BatchTransaction is one sale in a batch, carrying the arguments of
+// RecordTransaction.
+type BatchTransaction struct {
+	ID              string         `json:"id"`
+	RestaurantID    string         `json:"restaurant_id"`
+	Amount          string         `json:"amount"`
+	Currency        string         `json:"currency"`
+	StripePaymentID string         `json:"stripe_payment_id"`
+	BusinessTime    string         `json:"business_time"`
+	Breakdown       *SaleBreakdown `json:"breakdown,omitempty"`
+}
+
+// BatchItemResult reports what happened to the sale at Index in the batch.
+// Status is "recorded", "duplicate" for an idempotent retry of a stored sale,
+// or "failed" with the reason in Error.
+type BatchItemResult struct {
+	Index       int          `json:"index"`
+	ID          string       `json:"id"`
+	Status      string       `json:"status"`
+	Transaction *Transaction `json:"transaction,omitempty" metadata:",optional"`
+	Error       string       `json:"error,omitempty" metadata:",optional"`
+}
+
+// BatchResult is the outcome of RecordTransactionsBatch, with one item per
+// sale in the order they were submitted.
+type BatchResult struct {
+	Mode     string            `json:"mode"`
+	Recorded int               `json:"recorded"`
+	Failed   int               `json:"failed"`
+	Items    []BatchItemResult `json:"items"`
+}
+
+// RecordTransactionsBatch records many sales in one proposal, for terminals
+// uploading the sales they took while offline. transactionsJSON is a JSON
+// array of BatchTransaction, each validated exactly as RecordTransaction
+// would, in order, so later sales see the earlier ones: a repeated id or
+// Stripe payment is rejected and tiered commission is priced on the volume
+// so far. mode is "atomic", where any invalid sale fails the whole batch with
+// a BatchRejectedError listing every failure, or "best-effort", where the
+// valid sales are written and the rest reported as failed. idempotent has
+// the same meaning as for RecordTransaction.
+//
+// The batch emits a single TransactionsBatchRecorded event carrying the
+// sales it recorded, as Fabric keeps only one event per transaction.
+func (s *SmartContract) RecordTransactionsBatch(ctx contractapi.TransactionContextInterface, transactionsJSON string, mode string, idempotent bool) (*BatchResult, error) {
+	if mode != batchModeAtomic && mode != batchModeBestEffort {
+		return nil, fmt.Errorf("batch mode must be %q or %q", batchModeAtomic, batchModeBestEffort)
+	}
+	var sales []BatchTransaction
+	if err := json.Unmarshal([]byte(transactionsJSON), &sales); err != nil {
+		return nil, fmt.Errorf("transactions must be a JSON array: %v", err)
+	}
+	if len(sales) == 0 || len(sales) > maxBatchSize {
+		return nil, fmt.Errorf("a batch must hold between 1 and %d transactions", maxBatchSize)
+	}
+
+	// Fabric does not let a transaction read its own writes, so every sale
+	// is recorded against a cache that does, and each one's writes reach the
+	// ledger only once it has succeeded.
+	batch := newWriteCache(ctx.GetStub())
+	result := &BatchResult{Mode: mode, Items: []BatchItemResult{}}
+	recorded := []*Transaction{}
+	for i, sale := range sales {
+		item := newWriteCache(batch)
+		tx, isNew, err := recordSale(&cachedContext{ctx, item}, sale.ID, sale.RestaurantID, sale.Amount, sale.Currency, sale.StripePaymentID, sale.BusinessTime, sale.Breakdown, idempotent)
+		outcome := BatchItemResult{Index: i, ID: sale.ID}
+		switch {
+		case err != nil:
+			outcome.Status = batchItemFailed
+			outcome.Error = err.Error()
+			result.Failed++
+		case isNew:
+			outcome.Status = batchItemRecorded
+			outcome.Transaction = tx
+			result.Recorded++
+			recorded = append(recorded, tx)
+		default:
+			outcome.Status = batchItemDuplicate
+			outcome.Transaction = tx
+		}
+		result.Items = append(result.Items, outcome)
+
+		if err != nil {
+			continue
+		}
+		if err := item.flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	if result.Failed > 0 && mode == batchModeAtomic {
+		return nil, &BatchRejectedError{Size: len(sales), Failures: failedItems(result.Items)}
+	}
+	if err := batch.flush(); err != nil {
+		return nil, err
+	}
+	if len(recorded) > 0 {
+		if err := emitEvent(ctx, eventTransactionsBatchRecorded, recorded); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func failedItems(items []BatchItemResult) []BatchItemResult {
+	var failed []BatchItemResult
+	for _, item := range items {
+		if item.Status == batchItemFailed {
+			failed = append(failed, item)
+		}
+	}
+	return failed
+}
+
+// cachedContext is a transaction context whose stub is a writeCache.
+type cachedContext struct {
+	contractapi.TransactionContextInterface
+	stub shim.ChaincodeStubInterface
+}
+
+func (c *cachedContext) GetStub() shim.ChaincodeStubInterface {
+	return c.stub
+}
+
+// writeCache wraps a stub so that reads of a key return what was last
+// written to it, and holds the writes until flush passes them on. Plain key
+// reads and partial composite key queries see the cache; other range and
+// rich queries go to the wrapped stub.
+type writeCache struct {
+	shim.ChaincodeStubInterface
+	values  map[string][]byte
+	deleted map[string]bool
+	keys    []string
+}
+
+func newWriteCache(stub shim.ChaincodeStubInterface) *writeCache {
+	return &writeCache{
+		ChaincodeStubInterface: stub,
+		values:                 map[string][]byte{},
+		deleted:                map[string]bool{},
+	}
+}
+
+func (c *writeCache) GetState(key string) ([]byte, error) {
+	if c.deleted[key] {
+		return nil, nil
+	}
+	if value, ok := c.values[key]; ok {
+		return value, nil
+	}
+	return c.ChaincodeStubInterface.GetState(key)
+}
+
+func (c *writeCache) PutState(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	c.track(key)
+	c.values[key] = value
+	delete(c.deleted, key)
+	return nil
+}
+
+func (c *writeCache) DelState(key string) error {
+	c.track(key)
+	delete(c.values, key)
+	c.deleted[key] = true
+	return nil
+}
+
+// GetStateByPartialCompositeKey returns the wrapped stub's results with the
+// cached writes under the partial key laid over them, in key order.
+func (c *writeCache) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := c.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := c.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	values := map[string][]byte{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		values[queryResponse.Key] = queryResponse.Value
+	}
+	for _, key := range c.keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if c.deleted[key] {
+			delete(values, key)
+		} else {
+			values[key] = c.values[key]
+		}
+	}
+
+	results := &cachedResults{}
+	for key, value := range values {
+		results.kvs = append(results.kvs, &queryresult.KV{Key: key, Value: value})
+	}
+	sort.Slice(results.kvs, func(i, j int) bool { return results.kvs[i].Key < results.kvs[j].Key })
+	return results, nil
+}
+
+func (c *writeCache) track(key string) {
+	if _, ok := c.values[key]; !ok && !c.deleted[key] {
+		c.keys = append(c.keys, key)
+	}
+}
+
+// flush writes the cached changes, in the order their keys were first
+// written, to the wrapped stub.
+func (c *writeCache) flush() error {
+	for _, key := range c.keys {
+		var err error
+		if c.deleted[key] {
+			err = c.ChaincodeStubInterface.DelState(key)
+		} else {
+			err = c.ChaincodeStubInterface.PutState(key, c.values[key])
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write to world state: %v", err)
+		}
+	}
+	return nil
+}
+
+// cachedResults iterates over the results of a writeCache query.
+type cachedResults struct {
+	kvs []*queryresult.KV
+}
+
+func (r *cachedResults) HasNext() bool {
+	return len(r.kvs) > 0
+}
+
+func (r *cachedResults) Next() (*queryresult.KV, error) {
+	if len(r.kvs) == 0 {
+		return nil, fmt.Errorf("no more results")
+	}
+	kv := r.kvs[0]
+	r.kvs = r.kvs[1:]
+	return kv, nil
+}
+
+func (r *cachedResults) Close() error {
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// mixedBatch holds four sales, of which T2 reuses T1's Stripe payment and T3
+// is for an unregistered restaurant.
+const mixedBatch = `[
+	{"id": "T1", "restaurant_id": "R1", "amount": "20.00", "currency": "GBP", "stripe_payment_id": "ch_1"},
+	{"id": "T2", "restaurant_id": "R1", "amount": "30.00", "currency": "GBP", "stripe_payment_id": "ch_1"},
+	{"id": "T3", "restaurant_id": "R9", "amount": "10.00", "currency": "GBP", "stripe_payment_id": "ch_3"},
+	{"id": "T4", "restaurant_id": "R1", "amount": "40.00", "currency": "GBP", "stripe_payment_id": "ch_4"}
+]`
+
+func TestAtomicBatchRecordsNothingIfAnySaleFails(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+
+	_, err := n.submit(rolePOSTerminal, "RecordTransactionsBatch", mixedBatch, batchModeAtomic, "false")
+	if err == nil || !strings.Contains(err.Error(), codeBatchRejected) {
+		t.Fatalf("atomic batch with invalid sales: got %v, want %s", err, codeBatchRejected)
+	}
+	for _, id := range []string{"T2", "T3"} {
+		if !strings.Contains(err.Error(), id) {
+			t.Errorf("rejection %q does not name failed sale %s", err, id)
+		}
+	}
+	if _, err := n.submit(roleAuditor, "GetTransaction", "T1"); err == nil {
+		t.Error("valid sale of a rejected atomic batch was recorded")
+	}
+}
+
+func TestBestEffortBatchRecordsTheValidSales(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+
+	result := decode[BatchResult](t, n.mustSubmit(rolePOSTerminal, "RecordTransactionsBatch", mixedBatch, batchModeBestEffort, "false"))
+	if result.Mode != batchModeBestEffort || result.Recorded != 2 || result.Failed != 2 || len(result.Items) != 4 {
+		t.Fatalf("batch recorded %d and failed %d of %d items, want 2, 2 and 4", result.Recorded, result.Failed, len(result.Items))
+	}
+	for i, want := range []string{batchItemRecorded, batchItemFailed, batchItemFailed, batchItemRecorded} {
+		item := result.Items[i]
+		if item.Index != i || item.Status != want || (want == batchItemFailed) != (item.Error != "") {
+			t.Errorf("item %d is %+v, want %s", i, item, want)
+		}
+	}
+	for _, id := range []string{"T1", "T4"} {
+		n.mustSubmit(roleAuditor, "GetTransaction", id)
+	}
+	if _, err := n.submit(roleAuditor, "GetTransaction", "T2"); err == nil {
+		t.Error("failed sale of a best-effort batch was recorded")
+	}
+	if balance := decode[RestaurantBalance](t, n.mustSubmit(roleFinance, "GetRestaurantBalance", "R1")); balance.GrossSales.MinorUnits != 6000 {
+		t.Errorf("gross sales are %s, want the 60.00 GBP recorded", balance.GrossSales)
+	}
+}
+
+func TestBatchRejectsUnknownModeAndSize(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	if _, err := n.submit(rolePOSTerminal, "RecordTransactionsBatch", mixedBatch, "partial", "false"); err == nil {
+		t.Error("batch with an unknown mode was accepted")
+	}
+	if _, err := n.submit(rolePOSTerminal, "RecordTransactionsBatch", `[]`, batchModeAtomic, "false"); err == nil {
+		t.Error("empty batch was accepted")
+	}
+}
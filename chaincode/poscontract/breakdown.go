@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// LineItem is one product on an itemised sale. Total is Quantity times
+// UnitPrice.
+type LineItem struct {
+	SKU         string `json:"sku"`
+	Description string `json:"description,omitempty" metadata:",optional"`
+	Quantity    int64  `json:"quantity"`
+	UnitPrice   Money  `json:"unit_price"`
+	Total       Money  `json:"total"`
+}
+
+// TaxLine is the tax charged on a sale at one rate, on top of its prices.
+type TaxLine struct {
+	Name            string `json:"name,omitempty" metadata:",optional"`
+	RateBasisPoints int64  `json:"rate_bps"`
+	Taxable         Money  `json:"taxable_amount"`
+	Amount          Money  `json:"amount"`
+}
+
+// DiscountLine is a discount or coupon taken off a sale's subtotal.
+type DiscountLine struct {
+	Code        string `json:"code,omitempty" metadata:",optional"`
+	Description string `json:"description,omitempty" metadata:",optional"`
+	Amount      Money  `json:"amount"`
+}
+
+// SaleBreakdown is the itemisation a terminal may send with a sale, with
+// amounts as decimals in major units of the sale's currency like the sale's
+// own amount.
+type SaleBreakdown struct {
+	Items     []BreakdownItem     `json:"items,omitempty"`
+	Taxes     []BreakdownTax      `json:"taxes,omitempty"`
+	Discounts []BreakdownDiscount `json:"discounts,omitempty"`
+	Tip       string              `json:"tip,omitempty"`
+}
+
+// BreakdownItem is a line item in a SaleBreakdown.
+type BreakdownItem struct {
+	SKU         string `json:"sku"`
+	Description string `json:"description,omitempty"`
+	Quantity    int64  `json:"quantity"`
+	UnitPrice   string `json:"unit_price"`
+}
+
+// BreakdownTax is a tax line in a SaleBreakdown. TaxableAmount defaults to
+// the subtotal less discounts and Amount to the rate applied to it.
+type BreakdownTax struct {
+	Name            string `json:"name,omitempty"`
+	RateBasisPoints int64  `json:"rate_bps"`
+	TaxableAmount   string `json:"taxable_amount,omitempty"`
+	Amount          string `json:"amount,omitempty"`
+}
+
+// BreakdownDiscount is a discount or coupon line in a SaleBreakdown.
+type BreakdownDiscount struct {
+	Code        string `json:"code,omitempty"`
+	Description string `json:"description,omitempty"`
+	Amount      string `json:"amount"`
+}
+
+// parseSaleBreakdown decodes the breakdown argument of RecordTransaction. An
+// empty argument means the sale is not itemised.
+func parseSaleBreakdown(breakdownJSON string) (*SaleBreakdown, error) {
+	if breakdownJSON == "" {
+		return nil, nil
+	}
+	var breakdown SaleBreakdown
+	if err := json.Unmarshal([]byte(breakdownJSON), &breakdown); err != nil {
+		return nil, fmt.Errorf("breakdown must be a JSON object: %v", err)
+	}
+	return &breakdown, nil
+}
+
+// itemize sets the sale's line items, taxes, discounts, tip and subtotal from
+// breakdown and checks that they add up to its amount: the subtotal less
+// discounts plus taxes and tip. A sale sent without line items has its
+// subtotal worked out from the rest, and its tax lines must then give their
+// taxable amount. A tax amount sent by the terminal may differ from the rate
+// applied to the taxable amount by one minor unit of rounding.
+func (tx *Transaction) itemize(breakdown *SaleBreakdown) error {
+	if breakdown == nil || (len(breakdown.Items) == 0 && len(breakdown.Taxes) == 0 && len(breakdown.Discounts) == 0 && breakdown.Tip == "") {
+		return nil
+	}
+	currency := tx.Amount.Currency
+
+	subtotal := Money{Currency: currency}
+	var items []LineItem
+	for i, item := range breakdown.Items {
+		if item.SKU == "" {
+			return fmt.Errorf("line item %d of transaction %s has no SKU", i, tx.ID)
+		}
+		if item.Quantity <= 0 {
+			return fmt.Errorf("line item %s of transaction %s must have a quantity greater than zero", item.SKU, tx.ID)
+		}
+		price, err := parseMoney(item.UnitPrice, currency)
+		if err != nil {
+			return fmt.Errorf("line item %s of transaction %s: %v", item.SKU, tx.ID, err)
+		}
+		if price.MinorUnits < 0 {
+			return fmt.Errorf("line item %s of transaction %s has a negative unit price", item.SKU, tx.ID)
+		}
+		total := Money{MinorUnits: price.MinorUnits * item.Quantity, Currency: currency}
+		if price.MinorUnits != 0 && total.MinorUnits/item.Quantity != price.MinorUnits {
+			return fmt.Errorf("line item %s of transaction %s is too large", item.SKU, tx.ID)
+		}
+		items = append(items, LineItem{SKU: item.SKU, Description: item.Description, Quantity: item.Quantity, UnitPrice: price, Total: total})
+		subtotal.MinorUnits += total.MinorUnits
+	}
+
+	discounted := Money{Currency: currency}
+	var discounts []DiscountLine
+	for i, discount := range breakdown.Discounts {
+		amount, err := parseMoney(discount.Amount, currency)
+		if err != nil {
+			return fmt.Errorf("discount %d of transaction %s: %v", i, tx.ID, err)
+		}
+		if amount.MinorUnits <= 0 {
+			return fmt.Errorf("discount %d of transaction %s must be greater than zero", i, tx.ID)
+		}
+		discounts = append(discounts, DiscountLine{Code: discount.Code, Description: discount.Description, Amount: amount})
+		discounted.MinorUnits += amount.MinorUnits
+	}
+
+	tip := Money{Currency: currency}
+	if breakdown.Tip != "" {
+		var err error
+		if tip, err = parseMoney(breakdown.Tip, currency); err != nil {
+			return fmt.Errorf("tip on transaction %s: %v", tx.ID, err)
+		}
+		if tip.MinorUnits < 0 {
+			return fmt.Errorf("tip on transaction %s cannot be negative", tx.ID)
+		}
+	}
+
+	taxed := Money{Currency: currency}
+	var taxes []TaxLine
+	for i, tax := range breakdown.Taxes {
+		if err := validateRate(tax.RateBasisPoints); err != nil {
+			return fmt.Errorf("tax line %d of transaction %s: %v", i, tx.ID, err)
+		}
+		taxable := Money{MinorUnits: subtotal.MinorUnits - discounted.MinorUnits, Currency: currency}
+		if tax.TaxableAmount != "" {
+			var err error
+			if taxable, err = parseMoney(tax.TaxableAmount, currency); err != nil {
+				return fmt.Errorf("tax line %d of transaction %s: %v", i, tx.ID, err)
+			}
+		} else if len(items) == 0 {
+			return fmt.Errorf("tax line %d of transaction %s needs a taxable amount, as the sale has no line items", i, tx.ID)
+		}
+		if taxable.MinorUnits < 0 {
+			return fmt.Errorf("tax line %d of transaction %s has a negative taxable amount", i, tx.ID)
+		}
+		amount := percentageOf(taxable, tax.RateBasisPoints)
+		if tax.Amount != "" {
+			charged, err := parseMoney(tax.Amount, currency)
+			if err != nil {
+				return fmt.Errorf("tax line %d of transaction %s: %v", i, tx.ID, err)
+			}
+			if difference := charged.MinorUnits - amount.MinorUnits; difference < -1 || difference > 1 {
+				return fmt.Errorf("tax line %d of transaction %s charges %s, but %d bps of %s is %s", i, tx.ID, charged, tax.RateBasisPoints, taxable, amount)
+			}
+			amount = charged
+		}
+		taxes = append(taxes, TaxLine{Name: tax.Name, RateBasisPoints: tax.RateBasisPoints, Taxable: taxable, Amount: amount})
+		taxed.MinorUnits += amount.MinorUnits
+	}
+
+	if len(items) == 0 {
+		subtotal.MinorUnits = tx.Amount.MinorUnits - tip.MinorUnits - taxed.MinorUnits + discounted.MinorUnits
+		if subtotal.MinorUnits < 0 {
+			return fmt.Errorf("taxes of %s and tip of %s on transaction %s exceed its amount of %s", taxed, tip, tx.ID, tx.Amount)
+		}
+	}
+	if discounted.MinorUnits > subtotal.MinorUnits {
+		return fmt.Errorf("discounts of %s on transaction %s exceed its subtotal of %s", discounted, tx.ID, subtotal)
+	}
+	expected := Money{MinorUnits: subtotal.MinorUnits - discounted.MinorUnits + taxed.MinorUnits + tip.MinorUnits, Currency: currency}
+	if expected != tx.Amount {
+		return fmt.Errorf("transaction %s amount %s does not match its breakdown: subtotal %s less discounts %s plus taxes %s and tip %s is %s", tx.ID, tx.Amount, subtotal, discounted, taxed, tip, expected)
+	}
+
+	tx.Subtotal = &subtotal
+	tx.Items = items
+	tx.Taxes = taxes
+	tx.Discounts = discounts
+	if breakdown.Tip != "" {
+		tx.Tip = &tip
+	}
+	return nil
+}
+
+// sameBreakdown reports whether two sales are itemised alike.
+func (tx *Transaction) sameBreakdown(other *Transaction) bool {
+	return reflect.DeepEqual(tx.Subtotal, other.Subtotal) &&
+		reflect.DeepEqual(tx.Items, other.Items) &&
+		reflect.DeepEqual(tx.Taxes, other.Taxes) &&
+		reflect.DeepEqual(tx.Discounts, other.Discounts) &&
+		reflect.DeepEqual(tx.Tip, other.Tip)
+}
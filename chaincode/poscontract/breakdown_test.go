@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestItemisedSaleMustAddUp(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	breakdown := `{"items": [
+		{"sku": "FLAT-WHITE", "quantity": 2, "unit_price": "4.50"},
+		{"sku": "CROISSANT", "quantity": 1, "unit_price": "3.25"}
+	], "discounts": [{"code": "LOYALTY", "amount": "1.25"}], "taxes": [{"name": "VAT", "rate_bps": 2000}], "tip": "1.00"}`
+
+	if _, err := n.submit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "14.21", "GBP", "ch_1", "", breakdown, "false"); err == nil {
+		t.Error("sale whose amount does not match its breakdown was accepted")
+	}
+	tx := decode[Transaction](t, n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "14.20", "GBP", "ch_1", "", breakdown, "false"))
+	if tx.Subtotal.MinorUnits != 1225 || len(tx.Items) != 2 || tx.Items[0].Total.MinorUnits != 900 {
+		t.Errorf("subtotal %s over %d items, want 12.25 GBP over 2 with 9.00 for the flat whites", tx.Subtotal, len(tx.Items))
+	}
+	if len(tx.Taxes) != 1 || tx.Taxes[0].Taxable.MinorUnits != 1100 || tx.Taxes[0].Amount.MinorUnits != 220 {
+		t.Errorf("taxes are %+v, want 2.20 GBP of VAT on 11.00", tx.Taxes)
+	}
+	if tx.Tip == nil || tx.Tip.MinorUnits != 100 || len(tx.Discounts) != 1 {
+		t.Errorf("tip %v and %d discounts, want 1.00 GBP and one", tx.Tip, len(tx.Discounts))
+	}
+}
+
+func TestBreakdownTaxAllowsOneMinorUnitOfRounding(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	// 20% of 10.01 is 2.002, which rounds to 2.00.
+	for _, sale := range []struct {
+		tax      string
+		amount   string
+		accepted bool
+	}{
+		{"1.98", "11.99", false},
+		{"1.99", "12.00", true},
+		{"2.00", "12.01", true},
+		{"2.01", "12.02", true},
+		{"2.02", "12.03", false},
+	} {
+		breakdown := `{"items": [{"sku": "LUNCH", "quantity": 1, "unit_price": "10.01"}], "taxes": [{"rate_bps": 2000, "amount": "` + sale.tax + `"}]}`
+		_, err := n.submit(rolePOSTerminal, "RecordTransaction", "T-"+sale.tax, "R1", sale.amount, "GBP", "ch_"+sale.tax, "", breakdown, "false")
+		if sale.accepted && err != nil {
+			t.Errorf("tax of %s on 10.01 GBP at 20%% rejected: %v", sale.tax, err)
+		}
+		if !sale.accepted && err == nil {
+			t.Errorf("tax of %s on 10.01 GBP at 20%% was accepted", sale.tax)
+		}
+	}
+}
+
+func TestInvalidBreakdownsAreRejected(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	for name, sale := range map[string][2]string{
+		"item without a SKU":           {"5.00", `{"items": [{"quantity": 1, "unit_price": "5.00"}]}`},
+		"item of no quantity":          {"5.00", `{"items": [{"sku": "TEA", "quantity": 0, "unit_price": "5.00"}]}`},
+		"negative unit price":          {"5.00", `{"items": [{"sku": "TEA", "quantity": 1, "unit_price": "-5.00"}]}`},
+		"discount beyond the subtotal": {"1.00", `{"items": [{"sku": "TEA", "quantity": 1, "unit_price": "2.00"}], "discounts": [{"amount": "3.00"}], "tip": "2.00"}`},
+		"negative tip":                 {"4.00", `{"items": [{"sku": "TEA", "quantity": 1, "unit_price": "5.00"}], "tip": "-1.00"}`},
+		"tax without a taxable amount": {"6.00", `{"taxes": [{"rate_bps": 2000}]}`},
+		"taxes beyond the amount":      {"1.00", `{"taxes": [{"rate_bps": 2000, "taxable_amount": "10.00"}]}`},
+		"malformed JSON":               {"5.00", `{"items": [`},
+	} {
+		if _, err := n.submit(rolePOSTerminal, "RecordTransaction", "T1", "R1", sale[0], "GBP", "ch_1", "", sale[1], "false"); err == nil {
+			t.Errorf("sale with %s was accepted", name)
+		}
+	}
+
+	// Without line items the subtotal is what the taxes and tip leave.
+	tx := decode[Transaction](t, n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "12.00", "GBP", "ch_1", "",
+		`{"taxes": [{"rate_bps": 2000, "taxable_amount": "10.00"}]}`, "false"))
+	if tx.Subtotal == nil || tx.Subtotal.MinorUnits != 1000 {
+		t.Errorf("subtotal is %v, want 10.00 GBP", tx.Subtotal)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const clawbackObjectType = "clawback"
+
+// Clawback is the negative balance a restaurant was left with when it was
+// offboarded: what it was paid out beyond what it earned, which it is asked
+// to repay. A restaurant has at most one.
+type Clawback struct {
+	ID           string `json:"id"`
+	RestaurantID string `json:"restaurant_id"`
+	Amount       Money  `json:"amount"`
+	Reason       string `json:"reason"`
+	Timestamp    string `json:"timestamp"`
+	RecordedBy   string `json:"recorded_by"`
+}
+
+// OffboardRestaurant removes a restaurant from the network for good. It must
+// have left any group and have nothing held in reserve, in unpaid payouts or
+// still available to pay out. A negative available balance is settled with a
+// clawback of the amount, journalled as owed by the restaurant.
+func (s *SmartContract) OffboardRestaurant(ctx contractapi.TransactionContextInterface, id string, reason string) (*Restaurant, error) {
+	if reason == "" {
+		return nil, fmt.Errorf("a reason is required to offboard restaurant %s", id)
+	}
+	restaurant, err := readRestaurant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if restaurant.Status != restaurantStatusActive && restaurant.Status != restaurantStatusSuspended {
+		return nil, &InvalidTransitionError{Kind: "restaurant", ID: id, From: restaurant.Status, To: restaurantStatusOffboarded}
+	}
+	if restaurant.GroupID != "" {
+		return nil, fmt.Errorf("restaurant %s must be removed from group %s before it is offboarded", id, restaurant.GroupID)
+	}
+
+	balance, err := findBalance(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if balance == nil {
+		balance = newBalance(id, restaurant.Currency)
+	}
+	if balance.Pending.MinorUnits != 0 {
+		return nil, fmt.Errorf("restaurant %s has %s in payouts that have not been paid", id, balance.Pending)
+	}
+	if balance.Reserved.MinorUnits != 0 {
+		return nil, fmt.Errorf("restaurant %s has %s held in reserve", id, balance.Reserved)
+	}
+	if balance.Available.MinorUnits > 0 {
+		return nil, fmt.Errorf("restaurant %s has %s still to be paid out", id, balance.Available)
+	}
+
+	if balance.Available.MinorUnits < 0 {
+		actor, err := clientID(ctx)
+		if err != nil {
+			return nil, err
+		}
+		timestamp, err := txTimestamp(ctx)
+		if err != nil {
+			return nil, err
+		}
+		clawback := &Clawback{
+			ID:           clawbackID(id),
+			RestaurantID: id,
+			Amount:       Money{MinorUnits: -balance.Available.MinorUnits, Currency: balance.Available.Currency},
+			Reason:       reason,
+			Timestamp:    timestamp,
+			RecordedBy:   actor,
+		}
+		if err := putRecord(ctx, clawbackObjectType, clawback.ID, clawback); err != nil {
+			return nil, err
+		}
+		if err := changeBalance(ctx, id, clawback.Amount.Currency, clawbackBalanceChange(clawback)); err != nil {
+			return nil, err
+		}
+		if err := postClawback(ctx, clawback); err != nil {
+			return nil, err
+		}
+		restaurant.ClawbackID = clawback.ID
+	}
+
+	restaurant.Status = restaurantStatusOffboarded
+	restaurant.OffboardReason = reason
+	if err := stampRestaurant(ctx, restaurant); err != nil {
+		return nil, err
+	}
+	if err := putRestaurant(ctx, restaurant); err != nil {
+		return nil, err
+	}
+	return restaurant, nil
+}
+
+func (s *SmartContract) GetClawback(ctx contractapi.TransactionContextInterface, id string) (*Clawback, error) {
+	clawback, err := findClawback(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if clawback == nil {
+		return nil, fmt.Errorf("clawback %s not found", id)
+	}
+	return clawback, nil
+}
+
+func (s *SmartContract) ListClawbacks(ctx contractapi.TransactionContextInterface) ([]*Clawback, error) {
+	return listRecords[Clawback](ctx, clawbackObjectType)
+}
+
+func clawbackID(restaurantID string) string {
+	return "clawback-" + restaurantID
+}
+
+// findClawback returns nil, nil when id is not on the ledger.
+func findClawback(ctx contractapi.TransactionContextInterface, id string) (*Clawback, error) {
+	return findRecord[Clawback](ctx, clawbackObjectType, id)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// overpaid registers R1 and pays out its 50.00 GBP sale before 20.00 GBP of
+// it is refunded, leaving R1 owing 20.00 GBP.
+func overpaid(t *testing.T, n *testNetwork) {
+	t.Helper()
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "50.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(roleFinance, "CreatePayout", "P1", "R1", "50.00", "GBP", `["T1"]`, "false")
+	markPaid(t, n, "P1")
+	n.mustSubmit(roleFinance, "RecordRefund", "RF1", "T1", "20.00", "re_1", "returned")
+}
+
+func TestShortfallCarriedForwardToNextPayout(t *testing.T) {
+	n := newTestNetwork(t)
+	overpaid(t, n)
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T2", "R1", "15.00", "GBP", "ch_2", "", "", "false")
+
+	first := decode[Payout](t, n.mustSubmit(roleFinance, "GeneratePayout", "R1", "2025-01-01T00:00:00Z", wholePeriod))
+	if first.TotalAmount.MinorUnits != 0 {
+		t.Fatalf("payout of 15.00 less a 20.00 refund pays %s, want nothing", first.TotalAmount)
+	}
+	markPaid(t, n, first.ID)
+	if balance := checkBalanceInvariant(t, n, "R1"); balance.Available.MinorUnits != -500 {
+		t.Errorf("available %s after the payout, want the 5.00 GBP shortfall owed", balance.Available)
+	}
+
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T3", "R1", "25.00", "GBP", "ch_3", "", "", "false")
+	second := decode[Payout](t, n.mustSubmit(roleFinance, "GeneratePayout", "R1", "2025-01-01T00:00:00Z", wholePeriod))
+	if strings.Join(second.TxIDs, ",") != "T3" || second.TotalAmount.MinorUnits != 2000 {
+		t.Errorf("next payout pays %s for %v, want 20.00 GBP for T3 less the 5.00 carried forward", second.TotalAmount, second.TxIDs)
+	}
+}
+
+func TestOffboardingClawsBackANegativeBalance(t *testing.T) {
+	n := newTestNetwork(t)
+	overpaid(t, n)
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T2", "R1", "30.00", "GBP", "ch_2", "", "", "false")
+
+	if _, err := n.submit(roleAdmin, "OffboardRestaurant", "R1", "closed down"); err == nil {
+		t.Fatal("restaurant still owed 10.00 GBP was offboarded")
+	}
+	n.mustSubmit(roleFinance, "CreatePayout", "P2", "R1", "10.00", "GBP", `["T2"]`, "false")
+	if _, err := n.submit(roleAdmin, "OffboardRestaurant", "R1", "closed down"); err == nil {
+		t.Fatal("restaurant with an unpaid payout was offboarded")
+	}
+	markPaid(t, n, "P2")
+	if _, err := n.submit(roleAdmin, "OffboardRestaurant", "R1", ""); err == nil {
+		t.Error("offboarding without a reason was accepted")
+	}
+
+	n.mustSubmit(roleFinance, "RecordRefund", "RF2", "T2", "12.00", "re_2", "returned")
+	restaurant := decode[Restaurant](t, n.mustSubmit(roleAdmin, "OffboardRestaurant", "R1", "closed down"))
+	if restaurant.Status != restaurantStatusOffboarded || restaurant.ClawbackID == "" {
+		t.Fatalf("restaurant is %s with clawback %q, want Offboarded with one", restaurant.Status, restaurant.ClawbackID)
+	}
+	clawback := decode[Clawback](t, n.mustSubmit(roleFinance, "GetClawback", restaurant.ClawbackID))
+	if clawback.Amount.MinorUnits != 1200 || clawback.Reason != "closed down" {
+		t.Errorf("clawback is %s for %q, want 12.00 GBP for the offboarding reason", clawback.Amount, clawback.Reason)
+	}
+	balance := checkBalanceInvariant(t, n, "R1")
+	if balance.Available.MinorUnits != 0 || balance.ClawedBack.MinorUnits != 1200 {
+		t.Errorf("available %s and clawed back %s, want 0 and 12.00 GBP", balance.Available, balance.ClawedBack)
+	}
+	statement := decode[AccountStatement](t, n.mustSubmit(roleAuditor, "GetAccountStatement", accountRestaurantReceivable, "R1", "GBP"))
+	if statement.Balance.MinorUnits != 1200 {
+		t.Errorf("R1 owes %s on its receivable account, want 12.00 GBP", statement.Balance)
+	}
+
+	_, err := n.submit(roleAdmin, "OffboardRestaurant", "R1", "again")
+	if err == nil || !strings.Contains(err.Error(), codeInvalidTransition) {
+		t.Errorf("second offboarding: got %v, want %s", err, codeInvalidTransition)
+	}
+	if _, err := n.submit(rolePOSTerminal, "RecordTransaction", "T4", "R1", "5.00", "GBP", "ch_4", "", "", "false"); err == nil {
+		t.Error("sale for an offboarded restaurant was accepted")
+	}
+}
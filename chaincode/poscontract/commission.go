@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	commissionPlanObjectType = "plan"
+	monthlyVolumeObjectType  = "volume"
+)
+
+const (
+	commissionPercentage = "percentage"
+	commissionFixed      = "fixed"
+	commissionTiered     = "tiered"
+)
+
+// basisPointsScale is 100%, in basis points.
+const basisPointsScale = 10000
+
+// CommissionPlan sets what is taken from each sale of the restaurants on it:
+// the platform's commission, worked out according to Type, and the payment
+// processor's fee, which is passed on to the restaurant.
+type CommissionPlan struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// RateBasisPoints is the commission of a percentage plan.
+	RateBasisPoints int64 `json:"rate_bps,omitempty" metadata:",optional"`
+	// FixedFee is the commission per sale of a fixed plan.
+	FixedFee *Money `json:"fixed_fee,omitempty" metadata:",optional"`
+	// Tiers are the rates of a tiered plan by the restaurant's volume so far
+	// in the calendar month of the sale.
+	Tiers                    []CommissionTier `json:"tiers,omitempty" metadata:",optional"`
+	ProcessorRateBasisPoints int64            `json:"processor_rate_bps"`
+	ProcessorFixedFee        *Money           `json:"processor_fixed_fee,omitempty" metadata:",optional"`
+}
+
+// CommissionTier applies RateBasisPoints once a restaurant's gross sales in
+// the month reach FromVolume.
+type CommissionTier struct {
+	FromVolume      Money `json:"from_volume"`
+	RateBasisPoints int64 `json:"rate_bps"`
+}
+
+// SetCommissionPlan creates or replaces a commission plan. Sales already
+// recorded keep the amounts worked out under the plan at the time.
+func (s *SmartContract) SetCommissionPlan(ctx contractapi.TransactionContextInterface, plan CommissionPlan) (*CommissionPlan, error) {
+	if err := plan.validate(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(plan.Tiers, func(i, j int) bool {
+		return plan.Tiers[i].FromVolume.MinorUnits < plan.Tiers[j].FromVolume.MinorUnits
+	})
+	if err := putRecord(ctx, commissionPlanObjectType, plan.ID, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func (s *SmartContract) GetCommissionPlan(ctx contractapi.TransactionContextInterface, id string) (*CommissionPlan, error) {
+	return readCommissionPlan(ctx, id)
+}
+
+func (s *SmartContract) ListCommissionPlans(ctx contractapi.TransactionContextInterface) ([]*CommissionPlan, error) {
+	return listRecords[CommissionPlan](ctx, commissionPlanObjectType)
+}
+
+func (plan *CommissionPlan) validate() error {
+	if plan.ID == "" {
+		return fmt.Errorf("commission plan id is required")
+	}
+	if err := validateRate(plan.ProcessorRateBasisPoints); err != nil {
+		return fmt.Errorf("commission plan %s processor rate: %v", plan.ID, err)
+	}
+	if err := validateFee(plan.ProcessorFixedFee); err != nil {
+		return fmt.Errorf("commission plan %s processor fixed fee: %v", plan.ID, err)
+	}
+
+	switch plan.Type {
+	case commissionPercentage:
+		if err := validateRate(plan.RateBasisPoints); err != nil {
+			return fmt.Errorf("commission plan %s rate: %v", plan.ID, err)
+		}
+	case commissionFixed:
+		if plan.FixedFee == nil {
+			return fmt.Errorf("commission plan %s is fixed but has no fixed fee", plan.ID)
+		}
+		if err := validateFee(plan.FixedFee); err != nil {
+			return fmt.Errorf("commission plan %s fixed fee: %v", plan.ID, err)
+		}
+	case commissionTiered:
+		if len(plan.Tiers) == 0 {
+			return fmt.Errorf("commission plan %s is tiered but has no tiers", plan.ID)
+		}
+		for _, tier := range plan.Tiers {
+			if err := validateRate(tier.RateBasisPoints); err != nil {
+				return fmt.Errorf("commission plan %s tier from %s: %v", plan.ID, tier.FromVolume, err)
+			}
+			if err := validateFee(&tier.FromVolume); err != nil {
+				return fmt.Errorf("commission plan %s tier volume: %v", plan.ID, err)
+			}
+			if tier.FromVolume.Currency != plan.Tiers[0].FromVolume.Currency {
+				return fmt.Errorf("commission plan %s mixes tier currencies", plan.ID)
+			}
+		}
+	default:
+		return fmt.Errorf("commission plan type must be %s, %s or %s, not %q", commissionPercentage, commissionFixed, commissionTiered, plan.Type)
+	}
+	return nil
+}
+
+// chargesIn fails if any fixed amount of the plan is in a currency other
+// than currency, which would make it unusable for a restaurant trading in it.
+func (plan *CommissionPlan) chargesIn(currency string) error {
+	amounts := []*Money{plan.FixedFee, plan.ProcessorFixedFee}
+	for i := range plan.Tiers {
+		amounts = append(amounts, &plan.Tiers[i].FromVolume)
+	}
+	for _, amount := range amounts {
+		if amount != nil && amount.Currency != currency {
+			return fmt.Errorf("commission plan %s has amounts in %s, not %s", plan.ID, amount.Currency, currency)
+		}
+	}
+	return nil
+}
+
+func validateRate(basisPoints int64) error {
+	if basisPoints < 0 || basisPoints > basisPointsScale {
+		return fmt.Errorf("%d basis points is outside 0 to %d", basisPoints, basisPointsScale)
+	}
+	return nil
+}
+
+func validateFee(fee *Money) error {
+	if fee == nil {
+		return nil
+	}
+	if _, err := currencyExponent(fee.Currency); err != nil {
+		return err
+	}
+	if fee.MinorUnits < 0 {
+		return fmt.Errorf("%s is negative", fee)
+	}
+	return nil
+}
+
+// saleFees are the deductions from a sale under a commission plan.
+type saleFees struct {
+	processorFee Money
+	commission   Money
+}
+
+// fees works out the processor fee and commission on gross, given the
+// restaurant's gross sales earlier in the same month.
+func (plan *CommissionPlan) fees(gross Money, monthVolume Money) (saleFees, error) {
+	fees := saleFees{
+		processorFee: percentageOf(gross, plan.ProcessorRateBasisPoints),
+		commission:   Money{Currency: gross.Currency},
+	}
+	if plan.ProcessorFixedFee != nil {
+		total, err := fees.processorFee.Add(*plan.ProcessorFixedFee)
+		if err != nil {
+			return saleFees{}, fmt.Errorf("commission plan %s processor fixed fee: %v", plan.ID, err)
+		}
+		fees.processorFee = total
+	}
+
+	switch plan.Type {
+	case commissionPercentage:
+		fees.commission = percentageOf(gross, plan.RateBasisPoints)
+	case commissionFixed:
+		if plan.FixedFee.Currency != gross.Currency {
+			return saleFees{}, fmt.Errorf("commission plan %s charges a fixed fee in %s, not %s", plan.ID, plan.FixedFee.Currency, gross.Currency)
+		}
+		fees.commission = *plan.FixedFee
+	case commissionTiered:
+		if plan.Tiers[0].FromVolume.Currency != gross.Currency {
+			return saleFees{}, fmt.Errorf("commission plan %s has tiers in %s, not %s", plan.ID, plan.Tiers[0].FromVolume.Currency, gross.Currency)
+		}
+		var rate int64
+		for _, tier := range plan.Tiers {
+			if monthVolume.MinorUnits >= tier.FromVolume.MinorUnits {
+				rate = tier.RateBasisPoints
+			}
+		}
+		fees.commission = percentageOf(gross, rate)
+	}
+	return fees, nil
+}
+
+// percentageOf returns basisPoints of amount, rounding half away from zero.
+func percentageOf(amount Money, basisPoints int64) Money {
+	scaled := amount.MinorUnits * basisPoints
+	half := int64(basisPointsScale / 2)
+	if scaled < 0 {
+		half = -half
+	}
+	return Money{MinorUnits: (scaled + half) / basisPointsScale, Currency: amount.Currency}
+}
+
+// applyCommission records the fees and net amount of tx under plan, the
+// commission plan of restaurant, or nil for a restaurant without one, which
+// pays no fees. monthVolume is the restaurant's gross sales earlier in the
+// month of the sale. A sale smaller than its fees is refused with
+// INVALID_AMOUNT, as it would leave the restaurant a negative net amount.
+func applyCommission(tx *Transaction, restaurant *Restaurant, plan *CommissionPlan, monthVolume Money) error {
+	fees := saleFees{processorFee: Money{Currency: tx.Amount.Currency}, commission: Money{Currency: tx.Amount.Currency}}
+	if plan != nil {
+		var err error
+		fees, err = plan.fees(tx.Amount, monthVolume)
+		if err != nil {
+			return err
+		}
+	}
+
+	total := Money{
+		MinorUnits: fees.processorFee.MinorUnits + fees.commission.MinorUnits,
+		Currency:   tx.Amount.Currency,
+	}
+	if total.MinorUnits > tx.Amount.MinorUnits {
+		return &InvalidAmountError{Kind: "transaction", ID: tx.ID, Amount: tx.Amount, Fees: &total}
+	}
+	net := Money{
+		MinorUnits: tx.Amount.MinorUnits - total.MinorUnits,
+		Currency:   tx.Amount.Currency,
+	}
+	tx.ProcessorFee = &fees.processorFee
+	tx.Commission = &fees.commission
+	tx.NetAmount = &net
+	tx.CommissionPlan = restaurant.CommissionPlan
+	return nil
+}
+
+func readCommissionPlan(ctx contractapi.TransactionContextInterface, id string) (*CommissionPlan, error) {
+	plan, err := findRecord[CommissionPlan](ctx, commissionPlanObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+	if plan == nil {
+		return nil, fmt.Errorf("commission plan %s not found", id)
+	}
+	return plan, nil
+}
+
+// priceSale works out the fees and net amount of tx under the plan of
+// restaurant. Only a tiered plan depends on the restaurant's volume for the
+// month, so only a sale priced under one reads the volume and is counted in
+// it. previous is the sale as it stood before a correction, or nil for a new
+// sale; it is taken out of any volume it was counted in.
+func priceSale(ctx contractapi.TransactionContextInterface, tx *Transaction, previous *Transaction, restaurant *Restaurant) error {
+	if previous != nil {
+		if err := removeSaleVolume(ctx, previous); err != nil {
+			return err
+		}
+	}
+	var plan *CommissionPlan
+	if restaurant.CommissionPlan != "" {
+		var err error
+		if plan, err = readCommissionPlan(ctx, restaurant.CommissionPlan); err != nil {
+			return err
+		}
+	}
+	if plan == nil || plan.Type != commissionTiered {
+		return applyCommission(tx, restaurant, plan, Money{Currency: tx.Amount.Currency})
+	}
+
+	month, err := saleMonth(tx)
+	if err != nil {
+		return err
+	}
+	volume, err := monthlyVolume(ctx, tx.RestaurantID, month, tx.Amount.Currency, tx.ID)
+	if err != nil {
+		return err
+	}
+	if err := applyCommission(tx, restaurant, plan, volume); err != nil {
+		return err
+	}
+	return putSaleVolume(ctx, tx, month)
+}
+
+// removeSaleVolume takes a sale out of its restaurant's volume for the month
+// it was recorded in, if it was counted there.
+func removeSaleVolume(ctx contractapi.TransactionContextInterface, tx *Transaction) error {
+	if !tx.countedInVolume() {
+		return nil
+	}
+	month, err := saleMonth(tx)
+	if err != nil {
+		return err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(monthlyVolumeObjectType, []string{tx.RestaurantID, month, tx.ID})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// saleMonth returns the calendar month, in UTC, of a sale's ledger timestamp.
+func saleMonth(tx *Transaction) (string, error) {
+	recorded, err := time.Parse(time.RFC3339, tx.Timestamp)
+	if err != nil {
+		return "", fmt.Errorf("transaction %s has an unreadable timestamp %q", tx.ID, tx.Timestamp)
+	}
+	return recorded.UTC().Format("2006-01"), nil
+}
+
+// monthlyVolume returns the gross sales counted for restaurantID in month,
+// which tiered commission plans are priced on, leaving out the sale with id
+// saleID. Each sale is counted under its own key and the month's volume is
+// their sum. Summing them ranges over the restaurant's keys for the month,
+// which Fabric checks again at commit, so of two concurrent sales of a
+// restaurant on a tiered plan the later to commit fails with
+// PHANTOM_READ_CONFLICT: tiered pricing serialises that restaurant's sales.
+// Restaurants on other plans keep no volume and are not affected.
+func monthlyVolume(ctx contractapi.TransactionContextInterface, restaurantID string, month string, currency string, saleID string) (Money, error) {
+	saleKey, err := ctx.GetStub().CreateCompositeKey(monthlyVolumeObjectType, []string{restaurantID, month, saleID})
+	if err != nil {
+		return Money{}, err
+	}
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(monthlyVolumeObjectType, []string{restaurantID, month})
+	if err != nil {
+		return Money{}, err
+	}
+	defer resultsIterator.Close()
+
+	volume := Money{Currency: currency}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return Money{}, err
+		}
+		if queryResponse.Key == saleKey {
+			continue
+		}
+		var counted Money
+		if err := json.Unmarshal(queryResponse.Value, &counted); err != nil {
+			return Money{}, fmt.Errorf("failed to decode monthly volume of %s for %s: %v", restaurantID, month, err)
+		}
+		if volume, err = volume.Add(counted); err != nil {
+			return Money{}, fmt.Errorf("monthly volume of %s for %s: %v", restaurantID, month, err)
+		}
+	}
+	return volume, nil
+}
+
+// putSaleVolume counts a sale in its restaurant's volume for month.
+func putSaleVolume(ctx contractapi.TransactionContextInterface, tx *Transaction, month string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(monthlyVolumeObjectType, []string{tx.RestaurantID, month, tx.ID})
+	if err != nil {
+		return err
+	}
+	volumeBytes, err := json.Marshal(tx.Amount)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, volumeBytes)
+}
+
+// countedInVolume reports whether tx may have been added to its restaurant's
+// monthly volume, which is the case for sales recorded with their fees.
+func (tx *Transaction) countedInVolume() bool {
+	return tx.NetAmount != nil
+}
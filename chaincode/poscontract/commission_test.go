@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// volumeKeys counts the keys kept for monthly volume.
+func volumeKeys(n *testNetwork) int {
+	count := 0
+	for key := range n.stub.State {
+		if strings.HasPrefix(key, "\x00"+monthlyVolumeObjectType+"\x00") {
+			count++
+		}
+	}
+	return count
+}
+
+func commissionOf(t *testing.T, n *testNetwork, id string) int64 {
+	t.Helper()
+	return decode[Transaction](t, n.mustSubmit(roleAuditor, "GetTransaction", id)).Commission.MinorUnits
+}
+
+func TestTieredCommissionCountsMonthlyVolume(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "SetCommissionPlan", `{"id": "tiered", "type": "tiered", "tiers": [
+		{"from_volume": {"minor_units": 0, "currency": "GBP"}, "rate_bps": 1000},
+		{"from_volume": {"minor_units": 10000, "currency": "GBP"}, "rate_bps": 500}
+	], "processor_rate_bps": 0}`)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "tiered")
+
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "80.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(rolePOSTerminal, "RecordTransactionsBatch", `[
+		{"id": "T2", "restaurant_id": "R1", "amount": "30.00", "currency": "GBP", "stripe_payment_id": "ch_2"},
+		{"id": "T3", "restaurant_id": "R1", "amount": "10.00", "currency": "GBP", "stripe_payment_id": "ch_3"}
+	]`, batchModeAtomic, "false")
+	for id, want := range map[string]int64{"T1": 800, "T2": 300, "T3": 50} {
+		if got := commissionOf(t, n, id); got != want {
+			t.Errorf("commission on %s is %d, want %d", id, got, want)
+		}
+	}
+	if count := volumeKeys(n); count != 3 {
+		t.Errorf("%d volume keys, want one per sale", count)
+	}
+
+	n.mustSubmit(roleAdmin, "VoidTransaction", "T1", "entered twice")
+	n.mustSubmit(roleAdmin, "UpdateTransaction", "T2", "R1", "20.00", "ch_2", "wrong amount")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T4", "R1", "10.00", "GBP", "ch_4", "", "", "false")
+	if got := commissionOf(t, n, "T2"); got != 200 {
+		t.Errorf("commission on corrected T2 is %d, want 200", got)
+	}
+	if got := commissionOf(t, n, "T4"); got != 100 {
+		t.Errorf("commission on T4 after the void is %d, want the lower tier's 100", got)
+	}
+}
+
+func TestFlatCommissionKeepsNoVolume(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "SetCommissionPlan", `{"id": "standard", "type": "percentage", "rate_bps": 1000, "processor_rate_bps": 150}`)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "standard")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "80.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(roleAdmin, "UpdateTransaction", "T1", "R1", "90.00", "ch_1", "wrong amount")
+	if count := volumeKeys(n); count != 0 {
+		t.Errorf("%d volume keys for a restaurant on a percentage plan, want none", count)
+	}
+}
+
+func TestSaleSmallerThanItsFeesIsRefused(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "SetCommissionPlan", `{"id": "standard", "type": "percentage", "rate_bps": 1000, "processor_rate_bps": 150,
+		"processor_fixed_fee": {"minor_units": 20, "currency": "GBP"}}`)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "standard")
+
+	_, err := n.submit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "0.10", "GBP", "ch_1", "", "", "false")
+	if err == nil || !strings.Contains(err.Error(), codeInvalidAmount) {
+		t.Fatalf("sale of 0.10 GBP against 0.21 GBP of fees: got %v, want %s", err, codeInvalidAmount)
+	}
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T2", "R1", "1.00", "GBP", "ch_2", "", "", "false")
+	if _, err := n.submit(roleAdmin, "UpdateTransaction", "T2", "R1", "0.10", "ch_2", "wrong amount"); err == nil {
+		t.Error("correction of a sale to less than its fees was accepted")
+	}
+	if net := decode[Transaction](t, n.mustSubmit(roleAuditor, "GetTransaction", "T2")).NetAmount.MinorUnits; net != 68 {
+		t.Errorf("net amount of T2 is %d, want 66", net)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// testNetwork runs the contract against a mock peer that behaves like a real
+// one where it matters: a transaction reads only committed state, never its
+// own writes, and its writes are committed only if it succeeds.
+type testNetwork struct {
+	t     *testing.T
+	cc    *contractapi.ContractChaincode
+	stub  *shimtest.MockStub
+	now   time.Time
+	txSeq int
+	certs map[string][]byte
+}
+
+func newTestNetwork(t *testing.T) *testNetwork {
+	t.Helper()
+	contract := &SmartContract{}
+	contract.BeforeTransaction = authorizeCaller
+	cc, err := contractapi.NewChaincode(contract)
+	if err != nil {
+		t.Fatalf("failed to create chaincode: %v", err)
+	}
+	return &testNetwork{
+		t:     t,
+		cc:    cc,
+		stub:  shimtest.NewMockStub("poscontract", cc),
+		now:   time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC),
+		certs: map[string][]byte{},
+	}
+}
+
+// advance moves the proposal clock on, for records that depend on time.
+func (n *testNetwork) advance(d time.Duration) {
+	n.now = n.now.Add(d)
+}
+
+// submit invokes function as a caller holding role and commits its writes if
+// it succeeds. Each call is a new proposal a second after the last.
+func (n *testNetwork) submit(role string, function string, args ...string) (string, error) {
+	n.t.Helper()
+	n.txSeq++
+	n.now = n.now.Add(time.Second)
+	txID := fmt.Sprintf("tx%d", n.txSeq)
+
+	n.stub.Creator = serializedIdentity("POSBusinessMSP", n.cert(role))
+	n.stub.MockTransactionStart(txID)
+	n.stub.TxTimestamp.Seconds = n.now.Unix()
+	n.stub.TxTimestamp.Nanos = 0
+	defer n.stub.MockTransactionEnd(txID)
+
+	peer := &peerStub{MockStub: n.stub, args: append([]string{function}, args...), writes: map[string][]byte{}, deletes: map[string]bool{}}
+	response := n.cc.Invoke(peer)
+	if response.Status != 200 {
+		return "", errors.New(response.Message)
+	}
+	for key, value := range peer.writes {
+		n.stub.PutState(key, value)
+	}
+	for key := range peer.deletes {
+		n.stub.DelState(key)
+	}
+	return string(response.Payload), nil
+}
+
+// mustSubmit is submit for calls that are expected to succeed.
+func (n *testNetwork) mustSubmit(role string, function string, args ...string) string {
+	n.t.Helper()
+	payload, err := n.submit(role, function, args...)
+	if err != nil {
+		n.t.Fatalf("%s%v failed: %v", function, args, err)
+	}
+	return payload
+}
+
+// cert returns a certificate for a caller holding role, which it carries as
+// an OU as cryptogen identities do.
+func (n *testNetwork) cert(role string) []byte {
+	if cert, ok := n.certs[role]; ok {
+		return cert
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		n.t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(len(n.certs) + 1)),
+		Subject:      pkix.Name{CommonName: role + "@pos.com", OrganizationalUnit: []string{role}},
+		NotBefore:    n.now.Add(-time.Hour),
+		NotAfter:     n.now.Add(24 * 365 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		n.t.Fatalf("failed to create certificate: %v", err)
+	}
+	n.certs[role] = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return n.certs[role]
+}
+
+// serializedIdentity encodes an msp.SerializedIdentity, whose two fields are
+// the MSP ID and the PEM certificate, in protobuf wire format.
+func serializedIdentity(mspID string, cert []byte) []byte {
+	var encoded []byte
+	for field, value := range [][]byte{[]byte(mspID), cert} {
+		encoded = append(encoded, byte(field+1)<<3|2)
+		encoded = binary.AppendUvarint(encoded, uint64(len(value)))
+		encoded = append(encoded, value...)
+	}
+	return encoded
+}
+
+// peerStub holds back a proposal's writes from its reads, as a peer does.
+type peerStub struct {
+	*shimtest.MockStub
+	args    []string
+	writes  map[string][]byte
+	deletes map[string]bool
+}
+
+func (p *peerStub) GetArgs() [][]byte {
+	args := make([][]byte, len(p.args))
+	for i, arg := range p.args {
+		args[i] = []byte(arg)
+	}
+	return args
+}
+
+func (p *peerStub) GetStringArgs() []string {
+	return p.args
+}
+
+func (p *peerStub) GetFunctionAndParameters() (string, []string) {
+	return p.args[0], p.args[1:]
+}
+
+func (p *peerStub) GetState(key string) ([]byte, error) {
+	return p.MockStub.State[key], nil
+}
+
+func (p *peerStub) PutState(key string, value []byte) error {
+	p.writes[key] = value
+	delete(p.deletes, key)
+	return nil
+}
+
+func (p *peerStub) DelState(key string) error {
+	delete(p.writes, key)
+	p.deletes[key] = true
+	return nil
+}
+
+func (p *peerStub) SetEvent(name string, payload []byte) error {
+	return nil
+}
+
+// GetStateByPartialCompositeKeyWithPagination pages through committed state
+// as the peer does: the bookmark is the key the next page starts at, and is
+// empty once no keys are left.
+func (p *peerStub) GetStateByPartialCompositeKeyWithPagination(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	resultsIterator, err := p.MockStub.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &cachedResults{}
+	metadata := &peer.QueryResponseMetadata{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if queryResponse.Key < bookmark {
+			continue
+		}
+		if int32(len(page.kvs)) == pageSize {
+			metadata.Bookmark = queryResponse.Key
+			break
+		}
+		page.kvs = append(page.kvs, queryResponse)
+	}
+	metadata.FetchedRecordsCount = int32(len(page.kvs))
+	return page, metadata, nil
+}
+
+// decode unmarshals a contract function's JSON result.
+func decode[T any](t *testing.T, payload string) *T {
+	t.Helper()
+	var value T
+	if err := json.Unmarshal([]byte(payload), &value); err != nil {
+		t.Fatalf("failed to decode %q: %v", payload, err)
+	}
+	return &value
+}
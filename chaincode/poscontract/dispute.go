@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const disputeObjectType = "dispute"
+
+const (
+	disputeStatusOpen        = "Open"
+	disputeStatusUnderReview = "UnderReview"
+	disputeStatusWon         = "Won"
+	disputeStatusLost        = "Lost"
+)
+
+// Outcomes ResolveDispute accepts.
+const (
+	disputeOutcomeWon  = "won"
+	disputeOutcomeLost = "lost"
+)
+
+// disputeTransitions lists the statuses each dispute status may move to. Won
+// and Lost are final.
+var disputeTransitions = map[string][]string{
+	disputeStatusOpen:        {disputeStatusUnderReview, disputeStatusWon, disputeStatusLost},
+	disputeStatusUnderReview: {disputeStatusUnderReview, disputeStatusWon, disputeStatusLost},
+}
+
+// Dispute is a cardholder's chargeback of a sale, raised through Stripe. It
+// is Open until evidence is submitted, UnderReview while the card issuer
+// decides, and then Won or Lost. A lost dispute takes the disputed amount
+// back from the restaurant as a chargeback adjustment, named in
+// AdjustmentID.
+type Dispute struct {
+	ID              string            `json:"id"`
+	TransactionID   string            `json:"transaction_id"`
+	RestaurantID    string            `json:"restaurant_id"`
+	Amount          Money             `json:"amount"`
+	StripeDisputeID string            `json:"stripe_dispute_id"`
+	Reason          string            `json:"reason"`
+	Status          string            `json:"status"`
+	OpenedAt        string            `json:"opened_at"`
+	OpenedBy        string            `json:"opened_by"`
+	Evidence        []DisputeEvidence `json:"evidence,omitempty" metadata:",optional"`
+	ResolvedAt      string            `json:"resolved_at,omitempty" metadata:",optional"`
+	ResolvedBy      string            `json:"resolved_by,omitempty" metadata:",optional"`
+	Resolution      string            `json:"resolution,omitempty" metadata:",optional"`
+	AdjustmentID    string            `json:"adjustment_id,omitempty" metadata:",optional"`
+}
+
+// DisputeEvidence is one piece of evidence submitted against a dispute.
+// Reference locates the document, e.g. a Stripe file id or a hash of it.
+type DisputeEvidence struct {
+	Description string `json:"description"`
+	Reference   string `json:"reference"`
+	SubmittedBy string `json:"submitted_by"`
+	SubmittedAt string `json:"submitted_at"`
+}
+
+// OpenDispute records a dispute of amount, a decimal in major units of the
+// sale's currency, against transactionID. The disputes on a sale that have
+// not been won, together with its refunds, may not exceed its amount. A sale
+// with disputes can no longer be changed or voided.
+func (s *SmartContract) OpenDispute(ctx contractapi.TransactionContextInterface, disputeID string, transactionID string, amount string, stripeDisputeID string, reason string) (*Dispute, error) {
+	if disputeID == "" || reason == "" {
+		return nil, fmt.Errorf("dispute id and reason are required")
+	}
+	existing, err := findDispute(ctx, disputeID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, &AlreadyExistsError{Kind: "dispute", ID: disputeID}
+	}
+
+	tx, err := readTransaction(ctx, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	if !tx.refundable() {
+		return nil, fmt.Errorf("transaction %s is %s and cannot be disputed", transactionID, tx.Status)
+	}
+	money, err := parseMoney(amount, tx.Amount.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if money.MinorUnits <= 0 {
+		return nil, fmt.Errorf("dispute amount must be greater than zero")
+	}
+
+	disputed, err := disputedAmount(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	contested := Money{MinorUnits: money.MinorUnits + disputed.MinorUnits, Currency: money.Currency}
+	if tx.RefundedAmount != nil {
+		contested.MinorUnits += tx.RefundedAmount.MinorUnits
+	}
+	if contested.MinorUnits > tx.Amount.MinorUnits {
+		return nil, fmt.Errorf("dispute of %s would take the refunds and disputes on transaction %s to %s, more than the sale amount of %s", money, transactionID, contested, tx.Amount)
+	}
+
+	actor, err := clientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dispute := Dispute{
+		ID:              disputeID,
+		TransactionID:   transactionID,
+		RestaurantID:    tx.RestaurantID,
+		Amount:          money,
+		StripeDisputeID: stripeDisputeID,
+		Reason:          reason,
+		Status:          disputeStatusOpen,
+		OpenedAt:        timestamp,
+		OpenedBy:        actor,
+	}
+	if err := putDispute(ctx, &dispute); err != nil {
+		return nil, err
+	}
+
+	tx.DisputeIDs = append(tx.DisputeIDs, disputeID)
+	if err := putTransaction(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := emitEvent(ctx, eventDisputeOpened, &dispute); err != nil {
+		return nil, err
+	}
+	return &dispute, nil
+}
+
+// SubmitDisputeEvidence adds a piece of evidence to an open dispute and puts
+// it under review.
+func (s *SmartContract) SubmitDisputeEvidence(ctx contractapi.TransactionContextInterface, disputeID string, description string, reference string) (*Dispute, error) {
+	if description == "" {
+		return nil, fmt.Errorf("a description is required for evidence on dispute %s", disputeID)
+	}
+	dispute, err := readDispute(ctx, disputeID)
+	if err != nil {
+		return nil, err
+	}
+	if !canTransitionDispute(dispute.Status, disputeStatusUnderReview) {
+		return nil, &InvalidTransitionError{Kind: "dispute", ID: disputeID, From: dispute.Status, To: disputeStatusUnderReview}
+	}
+
+	actor, err := clientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dispute.Status = disputeStatusUnderReview
+	dispute.Evidence = append(dispute.Evidence, DisputeEvidence{
+		Description: description,
+		Reference:   reference,
+		SubmittedBy: actor,
+		SubmittedAt: timestamp,
+	})
+	if err := putDispute(ctx, dispute); err != nil {
+		return nil, err
+	}
+	if err := emitEvent(ctx, eventDisputeEvidenceSubmitted, dispute); err != nil {
+		return nil, err
+	}
+	return dispute, nil
+}
+
+// ResolveDispute closes a dispute as won or lost. Losing it records a
+// chargeback adjustment for the disputed amount, which comes off the
+// restaurant's balance at once and is deducted from its next payout.
+func (s *SmartContract) ResolveDispute(ctx contractapi.TransactionContextInterface, disputeID string, outcome string, reason string) (*Dispute, error) {
+	if reason == "" {
+		return nil, fmt.Errorf("a reason is required to resolve dispute %s", disputeID)
+	}
+	var status string
+	switch outcome {
+	case disputeOutcomeWon:
+		status = disputeStatusWon
+	case disputeOutcomeLost:
+		status = disputeStatusLost
+	default:
+		return nil, fmt.Errorf("dispute outcome must be %q or %q, not %q", disputeOutcomeWon, disputeOutcomeLost, outcome)
+	}
+	dispute, err := readDispute(ctx, disputeID)
+	if err != nil {
+		return nil, err
+	}
+	if !canTransitionDispute(dispute.Status, status) {
+		return nil, &InvalidTransitionError{Kind: "dispute", ID: disputeID, From: dispute.Status, To: status}
+	}
+
+	actor, err := clientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dispute.Status = status
+	dispute.ResolvedAt = timestamp
+	dispute.ResolvedBy = actor
+	dispute.Resolution = reason
+
+	if status == disputeStatusLost {
+		adjustment := Adjustment{
+			ID:            "chargeback-" + disputeID,
+			Kind:          adjustmentKindChargeback,
+			RestaurantID:  dispute.RestaurantID,
+			TransactionID: dispute.TransactionID,
+			Reference:     disputeID,
+			Amount:        dispute.Amount,
+			Reason:        reason,
+		}
+		if err := recordAdjustment(ctx, &adjustment); err != nil {
+			return nil, err
+		}
+		dispute.AdjustmentID = adjustment.ID
+
+		tx, err := readTransaction(ctx, dispute.TransactionID)
+		if err != nil {
+			return nil, err
+		}
+		chargedBack := Money{Currency: tx.Amount.Currency}
+		if tx.ChargedBack != nil {
+			chargedBack = *tx.ChargedBack
+		}
+		chargedBack, err = chargedBack.Add(dispute.Amount)
+		if err != nil {
+			return nil, err
+		}
+		tx.ChargedBack = &chargedBack
+		if err := putTransaction(ctx, tx); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := putDispute(ctx, dispute); err != nil {
+		return nil, err
+	}
+	if err := emitEvent(ctx, eventDisputeResolved, dispute); err != nil {
+		return nil, err
+	}
+	return dispute, nil
+}
+
+func (s *SmartContract) GetDispute(ctx contractapi.TransactionContextInterface, id string) (*Dispute, error) {
+	return readDispute(ctx, id)
+}
+
+func (s *SmartContract) ListDisputes(ctx contractapi.TransactionContextInterface) ([]*Dispute, error) {
+	return listRecords[Dispute](ctx, disputeObjectType)
+}
+
+// disputedAmount is the total of the disputes on tx that have not been won:
+// those still open or under review, which may yet be lost, and those lost.
+func disputedAmount(ctx contractapi.TransactionContextInterface, tx *Transaction) (Money, error) {
+	disputed := Money{Currency: tx.Amount.Currency}
+	for _, id := range tx.DisputeIDs {
+		dispute, err := readDispute(ctx, id)
+		if err != nil {
+			return Money{}, err
+		}
+		if dispute.Status != disputeStatusWon {
+			disputed.MinorUnits += dispute.Amount.MinorUnits
+		}
+	}
+	return disputed, nil
+}
+
+func canTransitionDispute(from string, to string) bool {
+	for _, allowed := range disputeTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+func readDispute(ctx contractapi.TransactionContextInterface, id string) (*Dispute, error) {
+	dispute, err := findDispute(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if dispute == nil {
+		return nil, fmt.Errorf("dispute %s not found", id)
+	}
+	return dispute, nil
+}
+
+// findDispute returns nil, nil when id is not on the ledger.
+func findDispute(ctx contractapi.TransactionContextInterface, id string) (*Dispute, error) {
+	return findRecord[Dispute](ctx, disputeObjectType, id)
+}
+
+func putDispute(ctx contractapi.TransactionContextInterface, dispute *Dispute) error {
+	return putRecord(ctx, disputeObjectType, dispute.ID, dispute)
+}
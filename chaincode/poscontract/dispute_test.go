@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestRecordRefundCountsOpenDisputes(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "100.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(roleFinance, "OpenDispute", "D1", "T1", "60.00", "dp_1", "fraudulent")
+
+	if _, err := n.submit(roleFinance, "RecordRefund", "RF1", "T1", "100.00", "re_1", "customer request"); err == nil {
+		t.Fatal("refund of the full sale while 60.00 GBP of it is disputed was accepted")
+	}
+	n.mustSubmit(roleFinance, "RecordRefund", "RF1", "T1", "40.00", "re_1", "customer request")
+	if _, err := n.submit(roleFinance, "RecordRefund", "RF2", "T1", "0.01", "re_2", "customer request"); err == nil {
+		t.Fatal("refund beyond the undisputed part of the sale was accepted")
+	}
+
+	n.mustSubmit(roleFinance, "ResolveDispute", "D1", disputeOutcomeLost, "issuer sided with cardholder")
+	balance := decode[RestaurantBalance](t, n.mustSubmit(roleFinance, "GetRestaurantBalance", "R1"))
+	if balance.Refunds.MinorUnits+balance.Adjustments.MinorUnits != 10000 {
+		t.Errorf("refunds %s and chargebacks %s on a sale of 100.00 GBP, want them to add up to the sale", balance.Refunds, balance.Adjustments)
+	}
+}
+
+func TestRecordRefundAfterDisputeWon(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "100.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(roleFinance, "OpenDispute", "D1", "T1", "60.00", "dp_1", "fraudulent")
+	n.mustSubmit(roleFinance, "ResolveDispute", "D1", disputeOutcomeWon, "evidence accepted")
+
+	n.mustSubmit(roleFinance, "RecordRefund", "RF1", "T1", "100.00", "re_1", "customer request")
+}
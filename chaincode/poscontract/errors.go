@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Errors returned by the contract reach clients only as their message, so
+// each typed error starts with a stable code that callers can match on.
+const (
+	codeAlreadyExists       = "ALREADY_EXISTS"
+	codeInvalidTransition   = "INVALID_TRANSITION"
+	codeAccessDenied        = "ACCESS_DENIED"
+	codeBatchRejected       = "BATCH_REJECTED"
+	codeUnbalancedEntry     = "UNBALANCED_ENTRY"
+	codeInsufficientBalance = "INSUFFICIENT_BALANCE"
+	codeInvalidAmount       = "INVALID_AMOUNT"
+)
+
+// AlreadyExistsError is returned when a record is created under an id that is
+// already in use.
+type AlreadyExistsError struct {
+	Kind string
+	ID   string
+}
+
+func (e *AlreadyExistsError) Error() string {
+	return fmt.Sprintf("%s: %s %s already exists", codeAlreadyExists, e.Kind, e.ID)
+}
+
+// InvalidTransitionError is returned when a record is asked to move to a
+// status its lifecycle does not allow from the current one.
+type InvalidTransitionError struct {
+	Kind string
+	ID   string
+	From string
+	To   string
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("%s: %s %s cannot move from %q to %q", codeInvalidTransition, e.Kind, e.ID, e.From, e.To)
+}
+
+// AccessDeniedError is returned when the caller's role does not allow the
+// function it invoked. Role is empty when no role could be determined, in
+// which case Reason says why.
+type AccessDeniedError struct {
+	Function string
+	Role     string
+	Reason   string
+}
+
+func (e *AccessDeniedError) Error() string {
+	if e.Role == "" {
+		return fmt.Sprintf("%s: %s denied: %s", codeAccessDenied, e.Function, e.Reason)
+	}
+	return fmt.Sprintf("%s: role %q may not call %s", codeAccessDenied, e.Role, e.Function)
+}
+
+// BatchRejectedError is returned when an atomic batch holds invalid sales.
+// It lists every failure so the batch can be corrected in one go.
+type BatchRejectedError struct {
+	Size     int
+	Failures []BatchItemResult
+}
+
+func (e *BatchRejectedError) Error() string {
+	failures := make([]string, len(e.Failures))
+	for i, failure := range e.Failures {
+		failures[i] = fmt.Sprintf("#%d %s: %s", failure.Index, failure.ID, failure.Error)
+	}
+	return fmt.Sprintf("%s: %d of %d transactions failed: %s", codeBatchRejected, len(e.Failures), e.Size, strings.Join(failures, "; "))
+}
+
+// UnbalancedEntryError is returned when a journal entry's debits and credits
+// differ. Entries are built balanced, so it points to a bug rather than bad
+// input, and the whole invocation is rejected.
+type UnbalancedEntryError struct {
+	ID      string
+	Debits  Money
+	Credits Money
+}
+
+func (e *UnbalancedEntryError) Error() string {
+	return fmt.Sprintf("%s: journal entry %s debits %s but credits %s", codeUnbalancedEntry, e.ID, e.Debits, e.Credits)
+}
+
+// InsufficientBalanceError is returned when a payout would pay a restaurant
+// more than is available to it, leaving its balance below zero. Amount is
+// what the payout takes from the restaurant's available balance, including
+// any reserve it withholds.
+type InsufficientBalanceError struct {
+	RestaurantID string
+	Available    Money
+	Amount       Money
+}
+
+func (e *InsufficientBalanceError) Error() string {
+	return fmt.Sprintf("%s: payout of %s to restaurant %s exceeds its available balance of %s", codeInsufficientBalance, e.Amount, e.RestaurantID, e.Available)
+}
+
+// InvalidAmountError is returned when a record is given an amount it cannot
+// have, such as a sale of zero or less. Fees is set when the amount is
+// positive but smaller than the fees charged on it.
+type InvalidAmountError struct {
+	Kind   string
+	ID     string
+	Amount Money
+	Fees   *Money
+}
+
+func (e *InvalidAmountError) Error() string {
+	if e.Fees != nil {
+		return fmt.Sprintf("%s: %s %s amount %s does not cover its fees of %s", codeInvalidAmount, e.Kind, e.ID, e.Amount, *e.Fees)
+	}
+	return fmt.Sprintf("%s: %s %s amount %s must be greater than zero", codeInvalidAmount, e.Kind, e.ID, e.Amount)
+}
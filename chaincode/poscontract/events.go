@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Chaincode event names. Fabric keeps one event per transaction, so each
+// contract function emits at most one, describing the record it changed.
+const (
+	eventTransactionRecorded = "TransactionRecorded"
+	eventTransactionUpdated  = "TransactionUpdated"
+	eventTransactionVoided   = "TransactionVoided"
+	eventRefundRecorded      = "RefundRecorded"
+	eventPayoutCreated       = "PayoutCreated"
+	eventPayoutStatusChanged = "PayoutStatusChanged"
+
+	eventDisputeOpened            = "DisputeOpened"
+	eventDisputeEvidenceSubmitted = "DisputeEvidenceSubmitted"
+	eventDisputeResolved          = "DisputeResolved"
+
+	// eventTransactionsBatchRecorded carries the list of sales recorded.
+	eventTransactionsBatchRecorded = "TransactionsBatchRecorded"
+)
+
+// eventVersion is bumped whenever the payload changes in a way subscribers
+// must handle.
+const eventVersion = 1
+
+// ContractEvent is the JSON payload of every chaincode event. Record is the
+// changed record as stored after the change.
+type ContractEvent struct {
+	Version   int         `json:"version"`
+	Type      string      `json:"type"`
+	TxID      string      `json:"tx_id"`
+	Timestamp string      `json:"timestamp"`
+	Record    interface{} `json:"record"`
+}
+
+func emitEvent(ctx contractapi.TransactionContextInterface, eventType string, record interface{}) error {
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(ContractEvent{
+		Version:   eventVersion,
+		Type:      eventType,
+		TxID:      ctx.GetStub().GetTxID(),
+		Timestamp: timestamp,
+		Record:    record,
+	})
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().SetEvent(eventType, payload); err != nil {
+		return fmt.Errorf("failed to set %s event: %v", eventType, err)
+	}
+	return nil
+}
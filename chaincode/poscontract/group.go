@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const groupObjectType = "group"
+
+// RestaurantGroup is a chain whose locations, each a restaurant of its own,
+// are paid out together to the group's head office. A restaurant belongs to
+// at most one group, and all of a group's locations trade in its currency.
+type RestaurantGroup struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	LegalEntity string   `json:"legal_entity"`
+	Currency    string   `json:"currency"`
+	Locations   []string `json:"locations"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at,omitempty" metadata:",optional"`
+	UpdatedBy   string   `json:"updated_by,omitempty" metadata:",optional"`
+}
+
+// GroupBalance adds up the balances of a group's locations. The totals have
+// the same meaning as in RestaurantBalance.
+type GroupBalance struct {
+	GroupID     string               `json:"group_id"`
+	Available   Money                `json:"available"`
+	Reserved    Money                `json:"reserved"`
+	Pending     Money                `json:"pending"`
+	PaidOut     Money                `json:"paid_out"`
+	GrossSales  Money                `json:"gross_sales"`
+	Fees        Money                `json:"fees"`
+	Refunds     Money                `json:"refunds"`
+	Adjustments Money                `json:"adjustments"`
+	ClawedBack  Money                `json:"clawed_back"`
+	Locations   []*RestaurantBalance `json:"locations"`
+}
+
+// CreateRestaurantGroup registers a group with no locations. legalEntity is
+// the head office the group's payouts are made to.
+func (s *SmartContract) CreateRestaurantGroup(ctx contractapi.TransactionContextInterface, id string, name string, legalEntity string, currency string) (*RestaurantGroup, error) {
+	if id == "" || name == "" || legalEntity == "" {
+		return nil, fmt.Errorf("group id, name and legal entity are required")
+	}
+	if _, err := currencyExponent(currency); err != nil {
+		return nil, err
+	}
+	existing, err := findGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, &AlreadyExistsError{Kind: "restaurant group", ID: id}
+	}
+
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	group := RestaurantGroup{
+		ID:          id,
+		Name:        name,
+		LegalEntity: legalEntity,
+		Currency:    currency,
+		Locations:   []string{},
+		CreatedAt:   timestamp,
+	}
+	if err := putGroup(ctx, &group); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// AddGroupLocation makes a restaurant a location of a group. From then on
+// its sales are paid out with the group's and it can no longer be paid out
+// on its own.
+func (s *SmartContract) AddGroupLocation(ctx contractapi.TransactionContextInterface, groupID string, restaurantID string) (*RestaurantGroup, error) {
+	group, err := readGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	restaurant, err := readRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	if restaurant.GroupID != "" {
+		return nil, fmt.Errorf("restaurant %s is already a location of group %s", restaurantID, restaurant.GroupID)
+	}
+	if err := restaurant.tradesIn(group.Currency); err != nil {
+		return nil, err
+	}
+
+	restaurant.GroupID = groupID
+	group.Locations = append(group.Locations, restaurantID)
+	sort.Strings(group.Locations)
+	return group, putGroupMembership(ctx, group, restaurant)
+}
+
+// RemoveGroupLocation takes a restaurant out of a group, after which it is
+// paid out on its own again. Group payouts already made keep their lines for
+// it.
+func (s *SmartContract) RemoveGroupLocation(ctx contractapi.TransactionContextInterface, groupID string, restaurantID string) (*RestaurantGroup, error) {
+	group, err := readGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	restaurant, err := readRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	if restaurant.GroupID != groupID {
+		return nil, fmt.Errorf("restaurant %s is not a location of group %s", restaurantID, groupID)
+	}
+
+	restaurant.GroupID = ""
+	locations := []string{}
+	for _, location := range group.Locations {
+		if location != restaurantID {
+			locations = append(locations, location)
+		}
+	}
+	group.Locations = locations
+	return group, putGroupMembership(ctx, group, restaurant)
+}
+
+func (s *SmartContract) GetRestaurantGroup(ctx contractapi.TransactionContextInterface, id string) (*RestaurantGroup, error) {
+	return readGroup(ctx, id)
+}
+
+func (s *SmartContract) ListRestaurantGroups(ctx contractapi.TransactionContextInterface) ([]*RestaurantGroup, error) {
+	return listRecords[RestaurantGroup](ctx, groupObjectType)
+}
+
+// GenerateGroupPayout creates one payout to a group's head office covering
+// every active location, built as GeneratePayout would build each location's
+// payout. A suspended location's sales wait until it is reinstated, but its
+// released reserves are paid back. The payout lists each line's location and
+// each location's share. A location
+// whose share would be negative carries it forward to its next payout rather
+// than reducing the other locations' shares.
+func (s *SmartContract) GenerateGroupPayout(ctx contractapi.TransactionContextInterface, groupID string, periodStart string, periodEnd string) (*Payout, error) {
+	payout, items, err := buildGroupPayout(ctx, groupID, periodStart, periodEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := items.lock(ctx, payout.ID); err != nil {
+		return nil, err
+	}
+	if err := putPayout(ctx, payout); err != nil {
+		return nil, err
+	}
+	if err := applyNewPayoutBalances(ctx, payout); err != nil {
+		return nil, err
+	}
+	if err := emitEvent(ctx, eventPayoutCreated, payout); err != nil {
+		return nil, err
+	}
+	return payout, nil
+}
+
+// PreviewGroupPayout returns the payout GenerateGroupPayout would create for
+// the same arguments without writing anything.
+func (s *SmartContract) PreviewGroupPayout(ctx contractapi.TransactionContextInterface, groupID string, periodStart string, periodEnd string) (*Payout, error) {
+	payout, _, err := buildGroupPayout(ctx, groupID, periodStart, periodEnd)
+	return payout, err
+}
+
+// GetGroupBalance returns the balance of each of a group's locations and
+// their sum.
+func (s *SmartContract) GetGroupBalance(ctx contractapi.TransactionContextInterface, groupID string) (*GroupBalance, error) {
+	group, err := readGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	zero := Money{Currency: group.Currency}
+	total := &GroupBalance{
+		GroupID:     groupID,
+		Available:   zero,
+		Reserved:    zero,
+		Pending:     zero,
+		PaidOut:     zero,
+		GrossSales:  zero,
+		Fees:        zero,
+		Refunds:     zero,
+		Adjustments: zero,
+		ClawedBack:  zero,
+		Locations:   []*RestaurantBalance{},
+	}
+	for _, restaurantID := range group.Locations {
+		balance, err := findBalance(ctx, restaurantID)
+		if err != nil {
+			return nil, err
+		}
+		if balance == nil {
+			balance = newBalance(restaurantID, group.Currency)
+		}
+		total.Available.MinorUnits += balance.Available.MinorUnits
+		total.Reserved.MinorUnits += balance.Reserved.MinorUnits
+		total.Pending.MinorUnits += balance.Pending.MinorUnits
+		total.PaidOut.MinorUnits += balance.PaidOut.MinorUnits
+		total.GrossSales.MinorUnits += balance.GrossSales.MinorUnits
+		total.Fees.MinorUnits += balance.Fees.MinorUnits
+		total.Refunds.MinorUnits += balance.Refunds.MinorUnits
+		total.Adjustments.MinorUnits += balance.Adjustments.MinorUnits
+		total.ClawedBack.MinorUnits += balance.ClawedBack.MinorUnits
+		total.Locations = append(total.Locations, balance)
+	}
+	return total, nil
+}
+
+// GetGroupHistory returns the payouts made to a group and those made to its
+// current locations before they joined it, oldest first.
+func (s *SmartContract) GetGroupHistory(ctx contractapi.TransactionContextInterface, groupID string) ([]*Payout, error) {
+	group, err := readGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	locations := map[string]bool{}
+	for _, restaurantID := range group.Locations {
+		locations[restaurantID] = true
+	}
+
+	payouts, err := listRecords[Payout](ctx, payoutObjectType)
+	if err != nil {
+		return nil, err
+	}
+	history := []*Payout{}
+	for _, payout := range payouts {
+		if payout.GroupID == groupID || (payout.GroupID == "" && locations[payout.RestaurantID]) {
+			history = append(history, payout)
+		}
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].PayoutDate < history[j].PayoutDate
+	})
+	return history, nil
+}
+
+func buildGroupPayout(ctx contractapi.TransactionContextInterface, groupID string, periodStart string, periodEnd string) (*Payout, *payoutItems, error) {
+	start, end, err := parsePeriod(periodStart, periodEnd)
+	if err != nil {
+		return nil, nil, err
+	}
+	group, err := readGroup(ctx, groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var locations []*Restaurant
+	for _, restaurantID := range group.Locations {
+		restaurant, err := readRestaurant(ctx, restaurantID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if restaurant.Status == restaurantStatusActive || restaurant.Status == restaurantStatusSuspended {
+			locations = append(locations, restaurant)
+		}
+	}
+	if len(locations) == 0 {
+		return nil, nil, fmt.Errorf("group %s has no active or suspended locations", groupID)
+	}
+
+	items, err := periodItems(ctx, locations, start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	// As in GeneratePayout, a suspended location's sales are held back and
+	// only its released reserves are paid, net of its refunds and
+	// adjustments.
+	var transactions []*Transaction
+	for _, tx := range items.transactions {
+		if items.restaurants[tx.RestaurantID].Status != restaurantStatusSuspended {
+			transactions = append(transactions, tx)
+		}
+	}
+	items.transactions = transactions
+	if !items.pays() {
+		return nil, nil, fmt.Errorf("group %s has no unpaid sales between %s and %s and no released reserves to pay back", groupID, periodStart, periodEnd)
+	}
+
+	payout, err := newPeriodPayout(ctx, group.Currency, start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	payout.GroupID = groupID
+	if err := items.apply(payout); err != nil {
+		return nil, nil, fmt.Errorf("cannot generate a payout for group %s: %v", groupID, err)
+	}
+	return payout, items, nil
+}
+
+// paidIndividually fails if the restaurant is a location of a group, whose
+// sales may only be paid out with the group's.
+func (r *Restaurant) paidIndividually() error {
+	if r.GroupID != "" {
+		return fmt.Errorf("restaurant %s is paid out with group %s", r.ID, r.GroupID)
+	}
+	return nil
+}
+
+// putGroupMembership writes a group and a restaurant whose membership of it
+// has just changed.
+func putGroupMembership(ctx contractapi.TransactionContextInterface, group *RestaurantGroup, restaurant *Restaurant) error {
+	actor, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	group.UpdatedAt = timestamp
+	group.UpdatedBy = actor
+	if err := stampRestaurant(ctx, restaurant); err != nil {
+		return err
+	}
+
+	if err := putGroup(ctx, group); err != nil {
+		return err
+	}
+	return putRestaurant(ctx, restaurant)
+}
+
+func readGroup(ctx contractapi.TransactionContextInterface, id string) (*RestaurantGroup, error) {
+	group, err := findGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, fmt.Errorf("restaurant group %s not found", id)
+	}
+	return group, nil
+}
+
+// findGroup returns nil, nil when id is not registered.
+func findGroup(ctx contractapi.TransactionContextInterface, id string) (*RestaurantGroup, error) {
+	return findRecord[RestaurantGroup](ctx, groupObjectType, id)
+}
+
+func putGroup(ctx contractapi.TransactionContextInterface, group *RestaurantGroup) error {
+	return putRecord(ctx, groupObjectType, group.ID, group)
+}
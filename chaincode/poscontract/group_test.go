@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// groupOfTwo registers group G1 with locations R1 and R2, R2 withholding a
+// 10% reserve for 7 days.
+func groupOfTwo(t *testing.T, n *testNetwork) {
+	t.Helper()
+	n.mustSubmit(roleAdmin, "CreateRestaurantGroup", "G1", "Chain", "Chain Ltd", "GBP")
+	for _, id := range []string{"R1", "R2"} {
+		n.mustSubmit(roleAdmin, "RegisterRestaurant", id, "Cafe "+id, "Chain Ltd", "GBP", "")
+		n.mustSubmit(roleAdmin, "AddGroupLocation", "G1", id)
+	}
+	n.mustSubmit(roleAdmin, "SetRestaurantReserve", "R2", "1000", "7")
+}
+
+// shares maps each location of a group payout to its share in minor units.
+func shares(payout *Payout) map[string]int64 {
+	byLocation := map[string]int64{}
+	for _, location := range payout.Locations {
+		byLocation[location.RestaurantID] = location.Amount.MinorUnits
+	}
+	return byLocation
+}
+
+func TestGroupPayoutCoversEveryLocation(t *testing.T) {
+	n := newTestNetwork(t)
+	groupOfTwo(t, n)
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "50.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T2", "R2", "100.00", "GBP", "ch_2", "", "", "false")
+
+	if _, err := n.submit(roleFinance, "GeneratePayout", "R1", "2025-01-01T00:00:00Z", wholePeriod); err == nil {
+		t.Error("payout of a single location of a group was accepted")
+	}
+	payout := decode[Payout](t, n.mustSubmit(roleFinance, "GenerateGroupPayout", "G1", "2025-01-01T00:00:00Z", wholePeriod))
+	if payout.GroupID != "G1" || strings.Join(payout.TxIDs, ",") != "T1,T2" || payout.TotalAmount.MinorUnits != 14000 {
+		t.Errorf("group payout pays %s for %v, want 140.00 GBP for T1 and T2", payout.TotalAmount, payout.TxIDs)
+	}
+	if got := shares(payout); got["R1"] != 5000 || got["R2"] != 9000 {
+		t.Errorf("shares are %v, want R1 50.00 and R2 90.00 less its reserve", got)
+	}
+
+	balance := decode[RestaurantBalance](t, n.mustSubmit(roleFinance, "GetGroupBalance", "G1"))
+	if balance.Pending.MinorUnits != 14000 || balance.Reserved.MinorUnits != 1000 {
+		t.Errorf("group balance has %s pending and %s reserved, want 140.00 and 10.00 GBP", balance.Pending, balance.Reserved)
+	}
+	history := decode[[]*Payout](t, n.mustSubmit(roleAuditor, "GetGroupHistory", "G1"))
+	if len(*history) != 1 || (*history)[0].ID != payout.ID {
+		t.Errorf("group history lists %d payouts, want the group payout", len(*history))
+	}
+}
+
+func TestGroupPayoutPaysSuspendedLocationItsReleasedReserve(t *testing.T) {
+	n := newTestNetwork(t)
+	groupOfTwo(t, n)
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R2", "100.00", "GBP", "ch_1", "", "", "false")
+	first := decode[Payout](t, n.mustSubmit(roleFinance, "GenerateGroupPayout", "G1", "2025-01-01T00:00:00Z", wholePeriod))
+	markPaid(t, n, first.ID)
+
+	n.advance(8 * 24 * time.Hour)
+	n.mustSubmit(roleFinance, "ReleaseReserves", "R2")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T2", "R2", "20.00", "GBP", "ch_2", "", "", "false")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T3", "R1", "30.00", "GBP", "ch_3", "", "", "false")
+	n.mustSubmit(roleFinance, "RecordRefund", "RF1", "T1", "4.00", "re_1", "returned")
+	n.mustSubmit(roleAdmin, "SuspendRestaurant", "R2", "under review")
+
+	payout := decode[Payout](t, n.mustSubmit(roleFinance, "GenerateGroupPayout", "G1", "2025-01-01T00:00:00Z", "2027-01-01T00:00:00Z"))
+	if strings.Join(payout.TxIDs, ",") != "T3" {
+		t.Errorf("group payout pays sales %v, want only T3 of the active location", payout.TxIDs)
+	}
+	if got := shares(payout); got["R1"] != 3000 || got["R2"] != 600 {
+		t.Errorf("shares are %v, want R1 30.00 and R2 its 10.00 released reserve less the 4.00 refund", got)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// clientID returns the submitting identity as "<MSP ID>::x509::<subject>::<issuer>",
+// suitable for recording who made a change.
+func clientID(ctx contractapi.TransactionContextInterface) (string, error) {
+	identity := ctx.GetClientIdentity()
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to read client MSP ID: %v", err)
+	}
+	encoded, err := identity.GetID()
+	if err != nil {
+		return "", fmt.Errorf("failed to read client identity: %v", err)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode client identity: %v", err)
+	}
+	return mspID + "::" + string(decoded), nil
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const journalObjectType = "journal"
+
+// The chart of accounts. Restaurant payable is what the network owes
+// restaurants, restaurant reserve what it has withheld from them under
+// rolling reserves and restaurant receivable what offboarded restaurants owe
+// it back; all three are kept per restaurant. Processor clearing is money
+// held by the card processor and cash is the platform's bank account.
+const (
+	accountRestaurantPayable    = "restaurant_payable"
+	accountRestaurantReserve    = "restaurant_reserve"
+	accountRestaurantReceivable = "restaurant_receivable"
+	accountPlatformRevenue      = "platform_revenue"
+	accountProcessorClearing    = "processor_clearing"
+	accountCash                 = "cash"
+)
+
+// accountNormalSides gives the side each account's balance normally sits on,
+// which is the side its balance is reported as positive on.
+var accountNormalSides = map[string]string{
+	accountRestaurantPayable:    sideCredit,
+	accountRestaurantReserve:    sideCredit,
+	accountRestaurantReceivable: sideDebit,
+	accountPlatformRevenue:      sideCredit,
+	accountProcessorClearing:    sideDebit,
+	accountCash:                 sideDebit,
+}
+
+// chartOfAccounts lists the accounts in the order they are reported.
+var chartOfAccounts = []string{accountProcessorClearing, accountCash, accountRestaurantReceivable, accountRestaurantPayable, accountRestaurantReserve, accountPlatformRevenue}
+
+// restaurantAccounts are the accounts kept per restaurant, whose lines name
+// the restaurant.
+var restaurantAccounts = map[string]bool{
+	accountRestaurantPayable:    true,
+	accountRestaurantReserve:    true,
+	accountRestaurantReceivable: true,
+}
+
+const (
+	sideDebit  = "debit"
+	sideCredit = "credit"
+)
+
+// Kinds of journal entry, named after the event they record. Reversing an
+// entry posts the same lines on the opposite sides under kind + "_reversal".
+const (
+	entryKindSale           = "sale"
+	entryKindFee            = "fee"
+	entryKindRefund         = "refund"
+	entryKindPayout         = "payout"
+	entryKindAdjustment     = "adjustment"
+	entryKindReserveHold    = "reserve_hold"
+	entryKindReserveRelease = "reserve_release"
+	entryKindClawback       = "clawback"
+)
+
+// JournalEntry is one balanced posting to the chart of accounts. Reference is
+// the id of the sale, refund, adjustment, reserve or payout it records and
+// RestaurantID the restaurant it concerns, which a group payout has none of.
+type JournalEntry struct {
+	ID           string        `json:"id"`
+	Kind         string        `json:"kind"`
+	Reference    string        `json:"reference"`
+	RestaurantID string        `json:"restaurant_id,omitempty" metadata:",optional"`
+	Timestamp    string        `json:"timestamp"`
+	Lines        []JournalLine `json:"lines"`
+}
+
+// JournalLine debits or credits one account. Restaurant payable and reserve
+// lines name the restaurant the amount is owed to.
+type JournalLine struct {
+	Account      string `json:"account"`
+	RestaurantID string `json:"restaurant_id,omitempty" metadata:",optional"`
+	Side         string `json:"side"`
+	Amount       Money  `json:"amount"`
+}
+
+// TrialBalance totals every account's debits and credits in one currency.
+// Balanced is false if the journal's debits and credits do not agree, which
+// would mean an entry was written unbalanced.
+type TrialBalance struct {
+	Currency     string              `json:"currency"`
+	Accounts     []TrialBalanceTotal `json:"accounts"`
+	TotalDebits  Money               `json:"total_debits"`
+	TotalCredits Money               `json:"total_credits"`
+	Balanced     bool                `json:"balanced"`
+}
+
+// TrialBalanceTotal is one account's line in a trial balance. Balance is
+// positive when it sits on the account's normal side.
+type TrialBalanceTotal struct {
+	Account string `json:"account"`
+	Debits  Money  `json:"debits"`
+	Credits Money  `json:"credits"`
+	Balance Money  `json:"balance"`
+}
+
+// AccountStatement lists the postings to an account in time order with the
+// running balance after each.
+type AccountStatement struct {
+	Account      string          `json:"account"`
+	RestaurantID string          `json:"restaurant_id,omitempty" metadata:",optional"`
+	Currency     string          `json:"currency"`
+	Lines        []StatementLine `json:"lines"`
+	Balance      Money           `json:"balance"`
+}
+
+// StatementLine is one posting on an account statement.
+type StatementLine struct {
+	EntryID   string `json:"entry_id"`
+	Kind      string `json:"kind"`
+	Reference string `json:"reference"`
+	Timestamp string `json:"timestamp"`
+	Side      string `json:"side"`
+	Amount    Money  `json:"amount"`
+	Balance   Money  `json:"balance"`
+}
+
+// GetTrialBalance totals the journal by account for currency.
+func (s *SmartContract) GetTrialBalance(ctx contractapi.TransactionContextInterface, currency string) (*TrialBalance, error) {
+	if _, err := currencyExponent(currency); err != nil {
+		return nil, err
+	}
+	entries, err := listRecords[JournalEntry](ctx, journalObjectType)
+	if err != nil {
+		return nil, err
+	}
+
+	zero := Money{Currency: currency}
+	totals := map[string]*TrialBalanceTotal{}
+	for _, account := range chartOfAccounts {
+		totals[account] = &TrialBalanceTotal{Account: account, Debits: zero, Credits: zero, Balance: zero}
+	}
+	trial := &TrialBalance{Currency: currency, TotalDebits: zero, TotalCredits: zero}
+	for _, entry := range entries {
+		for _, line := range entry.Lines {
+			if line.Amount.Currency != currency {
+				continue
+			}
+			total := totals[line.Account]
+			if line.Side == sideDebit {
+				total.Debits.MinorUnits += line.Amount.MinorUnits
+				trial.TotalDebits.MinorUnits += line.Amount.MinorUnits
+			} else {
+				total.Credits.MinorUnits += line.Amount.MinorUnits
+				trial.TotalCredits.MinorUnits += line.Amount.MinorUnits
+			}
+			total.Balance.MinorUnits += signedAmount(line)
+		}
+	}
+	for _, account := range chartOfAccounts {
+		trial.Accounts = append(trial.Accounts, *totals[account])
+	}
+	trial.Balanced = trial.TotalDebits == trial.TotalCredits
+	return trial, nil
+}
+
+// GetAccountStatement lists the postings to account in currency. For the
+// accounts kept per restaurant, restaurantID narrows the statement to one
+// restaurant; it must be empty for the other accounts.
+func (s *SmartContract) GetAccountStatement(ctx contractapi.TransactionContextInterface, account string, restaurantID string, currency string) (*AccountStatement, error) {
+	if _, ok := accountNormalSides[account]; !ok {
+		return nil, fmt.Errorf("unknown account %q", account)
+	}
+	if restaurantID != "" && !restaurantAccounts[account] {
+		return nil, fmt.Errorf("%s is not kept per restaurant", account)
+	}
+	if _, err := currencyExponent(currency); err != nil {
+		return nil, err
+	}
+	entries, err := listRecords[JournalEntry](ctx, journalObjectType)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Timestamp != entries[j].Timestamp {
+			return entries[i].Timestamp < entries[j].Timestamp
+		}
+		return entries[i].ID < entries[j].ID
+	})
+
+	statement := &AccountStatement{
+		Account:      account,
+		RestaurantID: restaurantID,
+		Currency:     currency,
+		Lines:        []StatementLine{},
+		Balance:      Money{Currency: currency},
+	}
+	for _, entry := range entries {
+		for _, line := range entry.Lines {
+			if line.Account != account || line.Amount.Currency != currency {
+				continue
+			}
+			if restaurantID != "" && line.RestaurantID != restaurantID {
+				continue
+			}
+			statement.Balance.MinorUnits += signedAmount(line)
+			statement.Lines = append(statement.Lines, StatementLine{
+				EntryID:   entry.ID,
+				Kind:      entry.Kind,
+				Reference: entry.Reference,
+				Timestamp: entry.Timestamp,
+				Side:      line.Side,
+				Amount:    line.Amount,
+				Balance:   statement.Balance,
+			})
+		}
+	}
+	return statement, nil
+}
+
+// postSale journals a sale: the gross is due from the processor and owed to
+// the restaurant, and the fees are then taken back from the restaurant as
+// platform revenue and the processor's charge.
+func postSale(ctx contractapi.TransactionContextInterface, tx *Transaction) error {
+	for _, entry := range saleEntries(tx) {
+		if err := postJournalEntry(ctx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reverseSale journals the reversal of a sale's entries, for a void or a
+// correction.
+func reverseSale(ctx contractapi.TransactionContextInterface, tx *Transaction) error {
+	for _, entry := range saleEntries(tx) {
+		if err := postJournalEntry(ctx, entry.reversal()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saleEntries(tx *Transaction) []*JournalEntry {
+	sale := newJournalEntry(entryKindSale, tx.ID, tx.RestaurantID)
+	sale.add(accountProcessorClearing, "", sideDebit, tx.Amount)
+	sale.add(accountRestaurantPayable, tx.RestaurantID, sideCredit, tx.Amount)
+	entries := []*JournalEntry{sale}
+
+	fee := newJournalEntry(entryKindFee, tx.ID, tx.RestaurantID)
+	if tx.Commission != nil {
+		fee.add(accountRestaurantPayable, tx.RestaurantID, sideDebit, *tx.Commission)
+		fee.add(accountPlatformRevenue, "", sideCredit, *tx.Commission)
+	}
+	if tx.ProcessorFee != nil {
+		fee.add(accountRestaurantPayable, tx.RestaurantID, sideDebit, *tx.ProcessorFee)
+		fee.add(accountProcessorClearing, "", sideCredit, *tx.ProcessorFee)
+	}
+	if len(fee.Lines) > 0 {
+		entries = append(entries, fee)
+	}
+	return entries
+}
+
+// postRefund journals a refund, which the processor returns to the customer
+// out of what is owed to the restaurant.
+func postRefund(ctx contractapi.TransactionContextInterface, refund *Refund) error {
+	entry := newJournalEntry(entryKindRefund, refund.ID, refund.RestaurantID)
+	entry.add(accountRestaurantPayable, refund.RestaurantID, sideDebit, refund.Amount)
+	entry.add(accountProcessorClearing, "", sideCredit, refund.Amount)
+	return postJournalEntry(ctx, entry)
+}
+
+// postAdjustment journals a chargeback, which the processor takes back out
+// of what is owed to the restaurant just as it does a refund.
+func postAdjustment(ctx contractapi.TransactionContextInterface, adjustment *Adjustment) error {
+	entry := newJournalEntry(entryKindAdjustment, adjustment.ID, adjustment.RestaurantID)
+	entry.add(accountRestaurantPayable, adjustment.RestaurantID, sideDebit, adjustment.Amount)
+	entry.add(accountProcessorClearing, "", sideCredit, adjustment.Amount)
+	return postJournalEntry(ctx, entry)
+}
+
+// postPayout journals a paid payout, which settles what was owed to each
+// restaurant it pays from the platform's bank account.
+func postPayout(ctx contractapi.TransactionContextInterface, payout *Payout) error {
+	entry := newJournalEntry(entryKindPayout, payout.ID, payout.RestaurantID)
+	for _, share := range payout.restaurantShares() {
+		entry.add(accountRestaurantPayable, share.RestaurantID, sideDebit, share.Amount)
+	}
+	entry.add(accountCash, "", sideCredit, payout.TotalAmount)
+	return postJournalEntry(ctx, entry)
+}
+
+// postReserveHold journals a reserve withheld from a payout, which the
+// restaurant is still owed but only once the reserve is released.
+func postReserveHold(ctx contractapi.TransactionContextInterface, reserve *Reserve) error {
+	return postJournalEntry(ctx, reserveHoldEntry(reserve))
+}
+
+// reverseReserveHold journals the reversal of a reserve's hold, for a
+// cancelled payout.
+func reverseReserveHold(ctx contractapi.TransactionContextInterface, reserve *Reserve) error {
+	return postJournalEntry(ctx, reserveHoldEntry(reserve).reversal())
+}
+
+func reserveHoldEntry(reserve *Reserve) *JournalEntry {
+	entry := newJournalEntry(entryKindReserveHold, reserve.ID, reserve.RestaurantID)
+	entry.add(accountRestaurantPayable, reserve.RestaurantID, sideDebit, reserve.Amount)
+	entry.add(accountRestaurantReserve, reserve.RestaurantID, sideCredit, reserve.Amount)
+	return entry
+}
+
+// postReserveRelease journals a released reserve, which is owed to the
+// restaurant again.
+func postReserveRelease(ctx contractapi.TransactionContextInterface, reserve *Reserve) error {
+	entry := newJournalEntry(entryKindReserveRelease, reserve.ID, reserve.RestaurantID)
+	entry.add(accountRestaurantReserve, reserve.RestaurantID, sideDebit, reserve.Amount)
+	entry.add(accountRestaurantPayable, reserve.RestaurantID, sideCredit, reserve.Amount)
+	return postJournalEntry(ctx, entry)
+}
+
+// postClawback journals a clawback, which turns what an offboarded
+// restaurant was overpaid into a debt it owes the platform.
+func postClawback(ctx contractapi.TransactionContextInterface, clawback *Clawback) error {
+	entry := newJournalEntry(entryKindClawback, clawback.ID, clawback.RestaurantID)
+	entry.add(accountRestaurantReceivable, clawback.RestaurantID, sideDebit, clawback.Amount)
+	entry.add(accountRestaurantPayable, clawback.RestaurantID, sideCredit, clawback.Amount)
+	return postJournalEntry(ctx, entry)
+}
+
+func newJournalEntry(kind string, reference string, restaurantID string) *JournalEntry {
+	return &JournalEntry{Kind: kind, Reference: reference, RestaurantID: restaurantID}
+}
+
+// add appends a line to the entry, moving a negative amount to the opposite
+// side and leaving out a zero one.
+func (entry *JournalEntry) add(account string, restaurantID string, side string, amount Money) {
+	if amount.MinorUnits == 0 {
+		return
+	}
+	if amount.MinorUnits < 0 {
+		side = oppositeSide(side)
+		amount.MinorUnits = -amount.MinorUnits
+	}
+	entry.Lines = append(entry.Lines, JournalLine{Account: account, RestaurantID: restaurantID, Side: side, Amount: amount})
+}
+
+func (entry *JournalEntry) reversal() *JournalEntry {
+	reversed := newJournalEntry(entry.Kind+"_reversal", entry.Reference, entry.RestaurantID)
+	for _, line := range entry.Lines {
+		reversed.add(line.Account, line.RestaurantID, oppositeSide(line.Side), line.Amount)
+	}
+	return reversed
+}
+
+// postJournalEntry stamps the entry with the proposal's id and time, checks
+// that it balances and writes it. The id combines the Fabric transaction,
+// kind and reference, so it is the same on every endorser and unique within
+// the transaction.
+func postJournalEntry(ctx contractapi.TransactionContextInterface, entry *JournalEntry) error {
+	if len(entry.Lines) == 0 {
+		return nil
+	}
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	entry.ID = fmt.Sprintf("%s:%s:%s", ctx.GetStub().GetTxID(), entry.Kind, entry.Reference)
+	entry.Timestamp = timestamp
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	return putRecord(ctx, journalObjectType, entry.ID, entry)
+}
+
+// validate rejects entries that post to unknown accounts, mix currencies or
+// whose debits and credits differ.
+func (entry *JournalEntry) validate() error {
+	currency := entry.Lines[0].Amount.Currency
+	debits := Money{Currency: currency}
+	credits := Money{Currency: currency}
+	for _, line := range entry.Lines {
+		if _, ok := accountNormalSides[line.Account]; !ok {
+			return fmt.Errorf("journal entry %s posts to unknown account %q", entry.ID, line.Account)
+		}
+		if restaurantAccounts[line.Account] != (line.RestaurantID != "") {
+			return fmt.Errorf("journal entry %s names a restaurant on the wrong account", entry.ID)
+		}
+		if line.Amount.MinorUnits <= 0 {
+			return fmt.Errorf("journal entry %s has a line of %s, which is not positive", entry.ID, line.Amount)
+		}
+		var err error
+		switch line.Side {
+		case sideDebit:
+			debits, err = debits.Add(line.Amount)
+		case sideCredit:
+			credits, err = credits.Add(line.Amount)
+		default:
+			return fmt.Errorf("journal entry %s has a line on unknown side %q", entry.ID, line.Side)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if debits != credits {
+		return &UnbalancedEntryError{ID: entry.ID, Debits: debits, Credits: credits}
+	}
+	return nil
+}
+
+// signedAmount is the line's amount, positive if it is on its account's
+// normal side.
+func signedAmount(line JournalLine) int64 {
+	if line.Side == accountNormalSides[line.Account] {
+		return line.Amount.MinorUnits
+	}
+	return -line.Amount.MinorUnits
+}
+
+func oppositeSide(side string) string {
+	if side == sideDebit {
+		return sideCredit
+	}
+	return sideDebit
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJournalBalancesThroughSaleRefundAndPayout(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "SetCommissionPlan", `{"id": "standard", "type": "percentage", "rate_bps": 1000, "processor_rate_bps": 150,
+		"processor_fixed_fee": {"minor_units": 20, "currency": "GBP"}}`)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "standard")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "100.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(roleFinance, "RecordRefund", "RF1", "T1", "10.00", "re_1", "returned")
+	n.mustSubmit(roleFinance, "CreatePayout", "P1", "R1", "78.30", "GBP", `["T1"]`, "false")
+	markPaid(t, n, "P1")
+
+	trial := decode[TrialBalance](t, n.mustSubmit(roleAuditor, "GetTrialBalance", "GBP"))
+	if !trial.Balanced || trial.TotalDebits != trial.TotalCredits {
+		t.Fatalf("trial balance debits %s and credits %s", trial.TotalDebits, trial.TotalCredits)
+	}
+	balances := map[string]int64{}
+	for _, account := range trial.Accounts {
+		balances[account.Account] = account.Balance.MinorUnits
+	}
+	for account, want := range map[string]int64{
+		accountProcessorClearing: 10000 - 170 - 1000,
+		accountCash:              -7830,
+		accountRestaurantPayable: 0,
+		accountPlatformRevenue:   1000,
+	} {
+		if balances[account] != want {
+			t.Errorf("%s balance is %d, want %d", account, balances[account], want)
+		}
+	}
+
+	statement := decode[AccountStatement](t, n.mustSubmit(roleAuditor, "GetAccountStatement", accountRestaurantPayable, "R1", "GBP"))
+	if statement.Balance.MinorUnits != 0 || len(statement.Lines) == 0 {
+		t.Errorf("R1's payable statement has %d lines ending at %s, want it settled to zero", len(statement.Lines), statement.Balance)
+	}
+}
+
+func TestUnbalancedJournalEntryIsRejected(t *testing.T) {
+	gbp := func(minorUnits int64) Money { return Money{MinorUnits: minorUnits, Currency: "GBP"} }
+
+	entry := newJournalEntry(entryKindSale, "T1", "R1")
+	entry.add(accountProcessorClearing, "", sideDebit, gbp(1000))
+	entry.add(accountRestaurantPayable, "R1", sideCredit, gbp(990))
+	var unbalanced *UnbalancedEntryError
+	if err := entry.validate(); !errors.As(err, &unbalanced) || unbalanced.Debits != gbp(1000) || unbalanced.Credits != gbp(990) {
+		t.Errorf("unbalanced entry: got %v, want %s for 10.00 against 9.90", err, codeUnbalancedEntry)
+	}
+
+	misfiled := newJournalEntry(entryKindSale, "T1", "R1")
+	misfiled.add(accountProcessorClearing, "R1", sideDebit, gbp(1000))
+	misfiled.add(accountRestaurantPayable, "R1", sideCredit, gbp(1000))
+	if err := misfiled.validate(); err == nil {
+		t.Error("entry naming a restaurant on processor clearing was accepted")
+	}
+
+	balanced := newJournalEntry(entryKindSale, "T1", "R1")
+	balanced.add(accountProcessorClearing, "", sideDebit, gbp(1000))
+	balanced.add(accountRestaurantPayable, "R1", sideCredit, gbp(1000))
+	if err := balanced.validate(); err != nil {
+		t.Errorf("balanced entry rejected: %v", err)
+	}
+}
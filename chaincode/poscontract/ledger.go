@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// recordObjectTypes lists the namespaces searched when a caller looks a
+// record up by its bare id.
+var recordObjectTypes = []string{transactionObjectType, payoutObjectType, refundObjectType, restaurantObjectType, groupObjectType, reserveObjectType, disputeObjectType, adjustmentObjectType, clawbackObjectType}
+
+// txTimestamp returns the client-supplied proposal timestamp, which is the
+// same on every endorsing peer, formatted as RFC 3339 in UTC.
+func txTimestamp(ctx contractapi.TransactionContextInterface) (string, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+	return formatTimestamp(ts.Seconds, ts.Nanos), nil
+}
+
+func formatTimestamp(seconds int64, nanos int32) string {
+	return time.Unix(seconds, int64(nanos)).UTC().Format(time.RFC3339)
+}
+
+// parseBusinessTime normalises an optional caller-supplied RFC 3339 time to
+// UTC. An empty value is returned unchanged.
+func parseBusinessTime(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", fmt.Errorf("business time must be an RFC 3339 timestamp: %v", err)
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
+
+// findRecord decodes the record stored under objectType and id. It returns
+// nil, nil when there is none, for callers that treat a missing record as a
+// normal outcome.
+func findRecord[T any](ctx contractapi.TransactionContextInterface, objectType string, id string) (*T, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(objectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	recordBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if recordBytes == nil {
+		return nil, nil
+	}
+
+	var record T
+	if err := json.Unmarshal(recordBytes, &record); err != nil {
+		return nil, fmt.Errorf("failed to decode %s %s: %v", objectType, id, err)
+	}
+	return &record, nil
+}
+
+func putRecord(ctx contractapi.TransactionContextInterface, objectType string, id string, record interface{}) error {
+	key, err := ctx.GetStub().CreateCompositeKey(objectType, []string{id})
+	if err != nil {
+		return err
+	}
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, recordBytes)
+}
+
+func deleteRecord(ctx contractapi.TransactionContextInterface, objectType string, id string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(objectType, []string{id})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+func readTransaction(ctx contractapi.TransactionContextInterface, id string) (*Transaction, error) {
+	tx, err := findTransaction(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		return nil, fmt.Errorf("transaction %s not found", id)
+	}
+	return tx, nil
+}
+
+// findTransaction returns nil, nil when id is not on the ledger.
+func findTransaction(ctx contractapi.TransactionContextInterface, id string) (*Transaction, error) {
+	return findRecord[Transaction](ctx, transactionObjectType, id)
+}
+
+func putTransaction(ctx contractapi.TransactionContextInterface, tx *Transaction) error {
+	return putRecord(ctx, transactionObjectType, tx.ID, tx)
+}
+
+func readPayout(ctx contractapi.TransactionContextInterface, id string) (*Payout, error) {
+	payout, err := findPayout(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if payout == nil {
+		return nil, fmt.Errorf("payout %s not found", id)
+	}
+	return payout, nil
+}
+
+// findPayout returns nil, nil when id is not on the ledger.
+func findPayout(ctx contractapi.TransactionContextInterface, id string) (*Payout, error) {
+	return findRecord[Payout](ctx, payoutObjectType, id)
+}
+
+func putPayout(ctx contractapi.TransactionContextInterface, payout *Payout) error {
+	return putRecord(ctx, payoutObjectType, payout.ID, payout)
+}
+
+func readRefund(ctx contractapi.TransactionContextInterface, id string) (*Refund, error) {
+	refund, err := findRecord[Refund](ctx, refundObjectType, id)
+	if err != nil {
+		return nil, err
+	}
+	if refund == nil {
+		return nil, fmt.Errorf("refund %s not found", id)
+	}
+	return refund, nil
+}
+
+func putRefund(ctx contractapi.TransactionContextInterface, refund *Refund) error {
+	return putRecord(ctx, refundObjectType, refund.ID, refund)
+}
+
+// listRecords decodes every record stored under the given namespace.
+func listRecords[T any](ctx contractapi.TransactionContextInterface, objectType string) ([]*T, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	records := []*T{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var record T
+		if err := json.Unmarshal(queryResponse.Value, &record); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %v", queryResponse.Key, err)
+		}
+		records = append(records, &record)
+	}
+	return records, nil
+}
+
+// historyIterator returns the history of the given id: that of the first
+// typed namespace key that has any, followed by that of the legacy flat key,
+// so that records migrated by MigrateFlatRecords keep their earlier history.
+// Fabric returns a key's history newest first, and the flat key was written
+// before the typed one, so the result is newest first too.
+func historyIterator(ctx contractapi.TransactionContextInterface, id string) (shim.HistoryQueryIteratorInterface, error) {
+	history := &chainedHistoryIterator{}
+	for _, objectType := range recordObjectTypes {
+		key, err := ctx.GetStub().CreateCompositeKey(objectType, []string{id})
+		if err != nil {
+			return nil, err
+		}
+		resultsIterator, err := ctx.GetStub().GetHistoryForKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if resultsIterator.HasNext() {
+			history.iterators = append(history.iterators, resultsIterator)
+			break
+		}
+		resultsIterator.Close()
+	}
+
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(id)
+	if err != nil {
+		history.Close()
+		return nil, err
+	}
+	history.iterators = append(history.iterators, resultsIterator)
+	return history, nil
+}
+
+// chainedHistoryIterator iterates over the history of several keys, one
+// after the other.
+type chainedHistoryIterator struct {
+	iterators []shim.HistoryQueryIteratorInterface
+}
+
+func (c *chainedHistoryIterator) HasNext() bool {
+	for len(c.iterators) > 0 {
+		if c.iterators[0].HasNext() {
+			return true
+		}
+		c.iterators[0].Close()
+		c.iterators = c.iterators[1:]
+	}
+	return false
+}
+
+func (c *chainedHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	if !c.HasNext() {
+		return nil, fmt.Errorf("no more history")
+	}
+	return c.iterators[0].Next()
+}
+
+func (c *chainedHistoryIterator) Close() error {
+	var firstErr error
+	for _, resultsIterator := range c.iterators {
+		if err := resultsIterator.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	c.iterators = nil
+	return firstErr
+}
+
+// utcRecordTimestamps rewrites in UTC the timestamp and payout_date of a
+// legacy flat record, which earlier versions of the contract wrote with the
+// peer's local offset, so that they compare in time order with the
+// timestamps written since. The record's other fields are kept as they are.
+func utcRecordTimestamps(value []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"timestamp", "payout_date"} {
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		var timestamp string
+		if err := json.Unmarshal(raw, &timestamp); err != nil {
+			return nil, fmt.Errorf("%s is not a string: %v", name, err)
+		}
+		if timestamp == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q is not an RFC 3339 timestamp", name, timestamp)
+		}
+		if fields[name], err = json.Marshal(parsed.UTC().Format(time.RFC3339)); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// flatRecordObjectType works out which namespace a legacy flat record belongs
+// in from its JSON fields. It returns "" for anything it does not recognise.
+func flatRecordObjectType(value []byte) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return ""
+	}
+	if _, ok := fields["tx_ids"]; ok {
+		return payoutObjectType
+	}
+	if _, ok := fields["payout_date"]; ok {
+		return payoutObjectType
+	}
+	if _, ok := fields["stripe_payment_id"]; ok {
+		return transactionObjectType
+	}
+	return ""
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// fakeHistory is the history of one key, as the peer would return it.
+type fakeHistory struct {
+	txIDs  []string
+	closed bool
+}
+
+func (f *fakeHistory) HasNext() bool {
+	return len(f.txIDs) > 0
+}
+
+func (f *fakeHistory) Next() (*queryresult.KeyModification, error) {
+	txID := f.txIDs[0]
+	f.txIDs = f.txIDs[1:]
+	return &queryresult.KeyModification{TxId: txID}, nil
+}
+
+func (f *fakeHistory) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestChainedHistoryIteratorReturnsEachKeyInTurn(t *testing.T) {
+	typed := &fakeHistory{txIDs: []string{"tx4", "tx3"}}
+	flat := &fakeHistory{txIDs: []string{"tx2", "tx1"}}
+	history := &chainedHistoryIterator{iterators: []shim.HistoryQueryIteratorInterface{typed, flat}}
+
+	var txIDs []string
+	for history.HasNext() {
+		modification, err := history.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		txIDs = append(txIDs, modification.TxId)
+	}
+	if got := strings.Join(txIDs, ","); got != "tx4,tx3,tx2,tx1" {
+		t.Errorf("history is %s, want the typed key's followed by the flat key's", got)
+	}
+	history.Close()
+	if !typed.closed || !flat.closed {
+		t.Error("chained history left an iterator open")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// legacyCurrency is assumed for amounts written as bare floats before records
+// carried a currency; every sale taken at that point was in pounds.
+const legacyCurrency = "GBP"
+
+// currencyExponents maps the ISO 4217 codes the network accepts to the number
+// of minor-unit digits each one uses.
+var currencyExponents = map[string]int{
+	"AUD": 2,
+	"BDT": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"INR": 2,
+	"JPY": 0,
+	"KWD": 3,
+	"SGD": 2,
+	"USD": 2,
+}
+
+// Money is an amount held as an integer number of minor units (pence, cents)
+// of an ISO 4217 currency.
+type Money struct {
+	MinorUnits int64  `json:"minor_units"`
+	Currency   string `json:"currency"`
+}
+
+// parseMoney converts a decimal amount in major units, such as "12.50", into
+// Money without passing through floating point.
+func parseMoney(amount string, currency string) (Money, error) {
+	exponent, err := currencyExponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	value := strings.TrimSpace(amount)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(value, "-")
+
+	whole, fraction, _ := strings.Cut(value, ".")
+	if whole == "" && fraction == "" {
+		return Money{}, fmt.Errorf("amount must be a valid number: %q", amount)
+	}
+	if len(fraction) > exponent {
+		return Money{}, fmt.Errorf("amount %s has more than %d decimal places for %s", amount, exponent, currency)
+	}
+	digits := whole + fraction + strings.Repeat("0", exponent-len(fraction))
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return Money{}, fmt.Errorf("amount must be a valid number: %q", amount)
+		}
+	}
+
+	minorUnits, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("amount must be a valid number: %v", err)
+	}
+	if negative {
+		minorUnits = -minorUnits
+	}
+	return Money{MinorUnits: minorUnits, Currency: currency}, nil
+}
+
+func currencyExponent(currency string) (int, error) {
+	exponent, ok := currencyExponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("unsupported currency %q", currency)
+	}
+	return exponent, nil
+}
+
+// Add returns the sum of two amounts in the same currency.
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("cannot combine %s and %s amounts", m.Currency, other.Currency)
+	}
+	return Money{MinorUnits: m.MinorUnits + other.MinorUnits, Currency: m.Currency}, nil
+}
+
+func (m Money) String() string {
+	exponent := currencyExponents[m.Currency]
+	units := m.MinorUnits
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	if exponent == 0 {
+		return fmt.Sprintf("%s%d %s", sign, units, m.Currency)
+	}
+	scale := int64(math.Pow10(exponent))
+	return fmt.Sprintf("%s%d.%0*d %s", sign, units/scale, exponent, units%scale, m.Currency)
+}
+
+// UnmarshalJSON also accepts the bare float amounts stored by earlier
+// versions of the contract, reading them as major units of legacyCurrency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] != '{' && trimmed[0] != 'n' {
+		var legacy float64
+		if err := json.Unmarshal(trimmed, &legacy); err != nil {
+			return err
+		}
+		scale := math.Pow10(currencyExponents[legacyCurrency])
+		*m = Money{MinorUnits: int64(math.Round(legacy * scale)), Currency: legacyCurrency}
+		return nil
+	}
+
+	type money Money
+	return json.Unmarshal(data, (*money)(m))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// maxPageSize caps a single page so that one query cannot pull the whole of
+// the world state back through the peer.
+const maxPageSize = 500
+
+// RecordPage is one page of raw records. Bookmark is passed back to fetch
+// the next page and is empty once there are no more records.
+type RecordPage struct {
+	Records      []string `json:"records"`
+	Bookmark     string   `json:"bookmark"`
+	FetchedCount int32    `json:"fetched_count"`
+}
+
+// MetadataRecordPage is one page of records in the shape returned by
+// GetRecordsWithMetadata.
+type MetadataRecordPage struct {
+	Records      []map[string]interface{} `json:"records"`
+	Bookmark     string                   `json:"bookmark"`
+	FetchedCount int32                    `json:"fetched_count"`
+}
+
+// GetAllRecordsPage returns up to pageSize records of objectType, or of every
+// record type when objectType is empty, starting at bookmark. Paginated
+// queries are only available to evaluated, not submitted, transactions.
+func (s *SmartContract) GetAllRecordsPage(ctx contractapi.TransactionContextInterface, objectType string, pageSize int32, bookmark string) (*RecordPage, error) {
+	results, next, err := pageRecords(ctx, objectType, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &RecordPage{Records: []string{}, Bookmark: next}
+	for _, result := range results {
+		page.Records = append(page.Records, string(result.Value))
+	}
+	page.FetchedCount = int32(len(page.Records))
+	return page, nil
+}
+
+// GetRecordsWithMetadataPage is the paginated form of GetRecordsWithMetadata,
+// taking the same arguments as GetAllRecordsPage.
+func (s *SmartContract) GetRecordsWithMetadataPage(ctx contractapi.TransactionContextInterface, objectType string, pageSize int32, bookmark string) (*MetadataRecordPage, error) {
+	results, next, err := pageRecords(ctx, objectType, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &MetadataRecordPage{Records: []map[string]interface{}{}, Bookmark: next}
+	for _, result := range results {
+		resultType, attributes, err := ctx.GetStub().SplitCompositeKey(result.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		var entity interface{}
+		json.Unmarshal(result.Value, &entity)
+
+		page.Records = append(page.Records, map[string]interface{}{
+			"key":  attributes[0],
+			"type": resultType,
+			"data": entity,
+			"txId": "Click for History",
+		})
+	}
+	page.FetchedCount = int32(len(page.Records))
+	return page, nil
+}
+
+// pageRecords reads up to pageSize records across the requested record
+// types in the order of recordObjectTypes, moving on to the next type when
+// one runs out. The bookmark it returns is "<object type>:<Fabric bookmark>"
+// so that the next page resumes in the right namespace.
+func pageRecords(ctx contractapi.TransactionContextInterface, objectType string, pageSize int32, bookmark string) ([]*queryresult.KV, string, error) {
+	if pageSize < 1 || pageSize > maxPageSize {
+		return nil, "", fmt.Errorf("page size must be between 1 and %d", maxPageSize)
+	}
+
+	objectTypes := recordObjectTypes
+	if objectType != "" {
+		if !isRecordObjectType(objectType) {
+			return nil, "", fmt.Errorf("unknown record type %q", objectType)
+		}
+		objectTypes = []string{objectType}
+	}
+
+	start := 0
+	var position string
+	if bookmark != "" {
+		bookmarkType, inner, ok := strings.Cut(bookmark, ":")
+		for start < len(objectTypes) && objectTypes[start] != bookmarkType {
+			start++
+		}
+		if !ok || start == len(objectTypes) {
+			return nil, "", fmt.Errorf("invalid bookmark %q", bookmark)
+		}
+		position = inner
+	}
+
+	var results []*queryresult.KV
+	for i := start; i < len(objectTypes); i++ {
+		remaining := pageSize - int32(len(results))
+		resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(objectTypes[i], []string{}, remaining, position)
+		if err != nil {
+			return nil, "", err
+		}
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return nil, "", err
+			}
+			results = append(results, queryResponse)
+		}
+		resultsIterator.Close()
+		position = ""
+
+		if metadata.Bookmark != "" && metadata.FetchedRecordsCount == remaining {
+			return results, objectTypes[i] + ":" + metadata.Bookmark, nil
+		}
+		if int32(len(results)) == pageSize {
+			if i+1 < len(objectTypes) {
+				return results, objectTypes[i+1] + ":", nil
+			}
+			return results, "", nil
+		}
+	}
+	return results, "", nil
+}
+
+func isRecordObjectType(objectType string) bool {
+	for _, known := range recordObjectTypes {
+		if known == objectType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRecordPagesFollowBookmarksAcrossRecordTypes(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	for i := 1; i <= 3; i++ {
+		n.mustSubmit(rolePOSTerminal, "RecordTransaction", fmt.Sprintf("T%d", i), "R1", "20.00", "GBP", fmt.Sprintf("ch_%d", i), "", "", "false")
+	}
+	n.mustSubmit(roleFinance, "CreatePayout", "P1", "R1", "20.00", "GBP", `["T1"]`, "false")
+
+	var ids []string
+	bookmark := ""
+	for pages := 0; ; pages++ {
+		if pages == 5 {
+			t.Fatalf("still paging after %d pages, at bookmark %q", pages, bookmark)
+		}
+		page := decode[MetadataRecordPage](t, n.mustSubmit(roleAuditor, "GetRecordsWithMetadataPage", "", "2", bookmark))
+		if page.FetchedCount != int32(len(page.Records)) || len(page.Records) > 2 {
+			t.Fatalf("page of %d records reports %d fetched", len(page.Records), page.FetchedCount)
+		}
+		for _, record := range page.Records {
+			ids = append(ids, fmt.Sprintf("%s/%s", record["type"], record["key"]))
+		}
+		if page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+	if got := strings.Join(ids, ","); got != "txn/T1,txn/T2,txn/T3,payout/P1,restaurant/R1" {
+		t.Errorf("pages hold %s, want every sale, payout and restaurant once", got)
+	}
+
+	page := decode[RecordPage](t, n.mustSubmit(roleAuditor, "GetAllRecordsPage", transactionObjectType, "3", ""))
+	if page.FetchedCount != 3 || page.Bookmark != "" {
+		t.Errorf("page of every sale has %d records and bookmark %q, want 3 and none", page.FetchedCount, page.Bookmark)
+	}
+}
+
+func TestRecordPagesRejectBadArguments(t *testing.T) {
+	n := newTestNetwork(t)
+	for name, args := range map[string][]string{
+		"zero page size":     {"", "0", ""},
+		"oversized page":     {"", fmt.Sprint(maxPageSize + 1), ""},
+		"unknown type":       {"invoice", "10", ""},
+		"malformed bookmark": {"", "10", "no-type"},
+		"foreign bookmark":   {transactionObjectType, "10", payoutObjectType + ":"},
+	} {
+		if _, err := n.submit(roleAuditor, "GetAllRecordsPage", args...); err == nil {
+			t.Errorf("page with %s was accepted", name)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// GeneratePayout creates a payout for every settled sale of an active
+// restaurant recorded on the ledger in [periodStart, periodEnd) that is not
+// already part of a payout, less the restaurant's refunds and adjustments
+// recorded before periodEnd that no earlier payout has deducted. Released
+// reserves are paid back and the restaurant's rolling reserve is withheld as
+// in CreatePayout. A payout may pay back released reserves alone, and a
+// suspended restaurant's payout pays back nothing else. The payout id is
+// derived from the Fabric transaction id.
+func (s *SmartContract) GeneratePayout(ctx contractapi.TransactionContextInterface, restaurantID string, periodStart string, periodEnd string) (*Payout, error) {
+	payout, items, err := buildPeriodPayout(ctx, restaurantID, periodStart, periodEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := items.lock(ctx, payout.ID); err != nil {
+		return nil, err
+	}
+	if err := putPayout(ctx, payout); err != nil {
+		return nil, err
+	}
+	if err := applyNewPayoutBalances(ctx, payout); err != nil {
+		return nil, err
+	}
+	if err := emitEvent(ctx, eventPayoutCreated, payout); err != nil {
+		return nil, err
+	}
+	return payout, nil
+}
+
+// PreviewPayout returns the payout GeneratePayout would create for the same
+// arguments without writing anything.
+func (s *SmartContract) PreviewPayout(ctx contractapi.TransactionContextInterface, restaurantID string, periodStart string, periodEnd string) (*Payout, error) {
+	payout, _, err := buildPeriodPayout(ctx, restaurantID, periodStart, periodEnd)
+	return payout, err
+}
+
+func buildPeriodPayout(ctx contractapi.TransactionContextInterface, restaurantID string, periodStart string, periodEnd string) (*Payout, *payoutItems, error) {
+	start, end, err := parsePeriod(periodStart, periodEnd)
+	if err != nil {
+		return nil, nil, err
+	}
+	restaurant, err := payoutRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := restaurant.paidIndividually(); err != nil {
+		return nil, nil, err
+	}
+
+	items, err := periodItems(ctx, []*Restaurant{restaurant}, start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	if restaurant.Status == restaurantStatusSuspended {
+		items.transactions = nil
+	}
+	if !items.pays() {
+		return nil, nil, fmt.Errorf("restaurant %s has no unpaid sales between %s and %s and no released reserves to pay back", restaurantID, periodStart, periodEnd)
+	}
+
+	payout, err := newPeriodPayout(ctx, restaurant.Currency, start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	payout.RestaurantID = restaurantID
+	if err := items.apply(payout); err != nil {
+		return nil, nil, fmt.Errorf("cannot generate a payout for restaurant %s: %v", restaurantID, err)
+	}
+	return payout, items, nil
+}
+
+// periodItems collects the unpaid sales of the given restaurants recorded in
+// [start, end), their refunds and adjustments recorded before end that no
+// payout has deducted yet and their released reserves not yet paid back.
+func periodItems(ctx contractapi.TransactionContextInterface, restaurants []*Restaurant, start time.Time, end time.Time) (*payoutItems, error) {
+	items := &payoutItems{restaurants: map[string]*Restaurant{}}
+	for _, restaurant := range restaurants {
+		items.restaurants[restaurant.ID] = restaurant
+	}
+
+	for _, restaurant := range restaurants {
+		transactions, err := listRestaurantRecords[Transaction](ctx, transactionObjectType, restaurant.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range transactions {
+			if !tx.payable() {
+				continue
+			}
+			recorded, err := time.Parse(time.RFC3339, tx.Timestamp)
+			if err != nil {
+				return nil, fmt.Errorf("transaction %s has an unreadable timestamp %q", tx.ID, tx.Timestamp)
+			}
+			if recorded.Before(start) || !recorded.Before(end) {
+				continue
+			}
+			items.transactions = append(items.transactions, tx)
+		}
+
+		refunds, err := outstandingRefunds(ctx, restaurant.ID, end)
+		if err != nil {
+			return nil, err
+		}
+		items.refunds = append(items.refunds, refunds...)
+		adjustments, err := outstandingAdjustments(ctx, restaurant.ID, end)
+		if err != nil {
+			return nil, err
+		}
+		items.adjustments = append(items.adjustments, adjustments...)
+		releases, err := outstandingReleases(ctx, restaurant.ID)
+		if err != nil {
+			return nil, err
+		}
+		items.releases = append(items.releases, releases...)
+	}
+	return items, nil
+}
+
+// newPeriodPayout starts a Pending payout for [start, end) whose id is
+// derived from the Fabric transaction id.
+func newPeriodPayout(ctx contractapi.TransactionContextInterface, currency string, start time.Time, end time.Time) (*Payout, error) {
+	created, err := newPayoutTransition(ctx, "", payoutStatusPending, "generated for period")
+	if err != nil {
+		return nil, err
+	}
+	return &Payout{
+		ID:          "payout-" + ctx.GetStub().GetTxID(),
+		TotalAmount: Money{Currency: currency},
+		Status:      payoutStatusPending,
+		PayoutDate:  created.Timestamp,
+		PeriodStart: start.UTC().Format(time.RFC3339),
+		PeriodEnd:   end.UTC().Format(time.RFC3339),
+		Transitions: []PayoutTransition{created},
+	}, nil
+}
+
+func parsePeriod(periodStart string, periodEnd string) (time.Time, time.Time, error) {
+	start, err := time.Parse(time.RFC3339, periodStart)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("period start must be an RFC 3339 timestamp: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339, periodEnd)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("period end must be an RFC 3339 timestamp: %v", err)
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("period start %s must be before period end %s", periodStart, periodEnd)
+	}
+	return start, end, nil
+}
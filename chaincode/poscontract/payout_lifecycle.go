@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	payoutStatusPending   = "Pending"
+	payoutStatusApproved  = "Approved"
+	payoutStatusSubmitted = "Submitted"
+	payoutStatusPaid      = "Paid"
+	payoutStatusFailed    = "Failed"
+	payoutStatusCancelled = "Cancelled"
+)
+
+// payoutTransitions lists the statuses each payout status may move to. Paid
+// and Cancelled are final; a Failed payout may be resubmitted to the bank.
+var payoutTransitions = map[string][]string{
+	payoutStatusPending:   {payoutStatusApproved, payoutStatusCancelled},
+	payoutStatusApproved:  {payoutStatusSubmitted, payoutStatusCancelled},
+	payoutStatusSubmitted: {payoutStatusPaid, payoutStatusFailed},
+	payoutStatusFailed:    {payoutStatusSubmitted, payoutStatusCancelled},
+}
+
+// PayoutTransition is one entry in a payout's status trail.
+type PayoutTransition struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Reason    string `json:"reason"`
+	Actor     string `json:"actor"`
+	Timestamp string `json:"timestamp"`
+}
+
+func canTransitionPayout(from string, to string) bool {
+	for _, allowed := range payoutTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdatePayoutStatus moves a payout along its lifecycle, recording the reason
+// and the submitting identity on the payout's transition trail. Cancelling a
+// payout frees its sales, refunds and released reserves for a later payout
+// and cancels the reserves it withheld. A payout whose carried-forward
+// balance a later payout has deducted cannot be cancelled until that one is.
+func (s *SmartContract) UpdatePayoutStatus(ctx contractapi.TransactionContextInterface, id string, newStatus string, reason string) error {
+	if reason == "" {
+		return fmt.Errorf("a reason is required to change the status of payout %s", id)
+	}
+	payout, err := readPayout(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !canTransitionPayout(payout.Status, newStatus) {
+		return &InvalidTransitionError{Kind: "payout", ID: id, From: payout.Status, To: newStatus}
+	}
+
+	transition, err := newPayoutTransition(ctx, payout.Status, newStatus, reason)
+	if err != nil {
+		return err
+	}
+	var cancelled []*Reserve
+	if newStatus == payoutStatusCancelled {
+		if err := releasePayoutItems(ctx, payout); err != nil {
+			return err
+		}
+		if cancelled, err = cancelPayoutReserves(ctx, payout); err != nil {
+			return err
+		}
+	}
+
+	payout.Status = newStatus
+	payout.Transitions = append(payout.Transitions, transition)
+	if err := putPayout(ctx, payout); err != nil {
+		return err
+	}
+	statusChange := func(share PayoutLocation) balanceChange {
+		change := payoutStatusBalanceChange(share.Amount, newStatus)
+		for _, reserve := range cancelled {
+			if reserve.RestaurantID == share.RestaurantID {
+				change = change.plus(reserveBalanceChange(reserve.Amount).negate())
+			}
+		}
+		return change
+	}
+	if err := changePayoutBalances(ctx, payout, statusChange); err != nil {
+		return err
+	}
+	if newStatus == payoutStatusPaid {
+		if err := postPayout(ctx, payout); err != nil {
+			return err
+		}
+	}
+	return emitEvent(ctx, eventPayoutStatusChanged, payout)
+}
+
+func newPayoutTransition(ctx contractapi.TransactionContextInterface, from string, to string, reason string) (PayoutTransition, error) {
+	actor, err := clientID(ctx)
+	if err != nil {
+		return PayoutTransition{}, err
+	}
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return PayoutTransition{}, err
+	}
+	return PayoutTransition{From: from, To: to, Reason: reason, Actor: actor, Timestamp: timestamp}, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPayoutLifecycleRecordsEachTransition(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "20.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(roleFinance, "CreatePayout", "P1", "R1", "20.00", "GBP", `["T1"]`, "false")
+
+	if _, err := n.submit(roleFinance, "UpdatePayoutStatus", "P1", payoutStatusApproved, ""); err == nil {
+		t.Error("status change without a reason was accepted")
+	}
+	_, err := n.submit(roleFinance, "UpdatePayoutStatus", "P1", payoutStatusPaid, "skipped ahead")
+	if err == nil || !strings.Contains(err.Error(), codeInvalidTransition) {
+		t.Errorf("Pending to Paid: got %v, want %s", err, codeInvalidTransition)
+	}
+
+	steps := []string{payoutStatusApproved, payoutStatusSubmitted, payoutStatusFailed, payoutStatusSubmitted, payoutStatusPaid}
+	for _, status := range steps {
+		n.mustSubmit(roleFinance, "UpdatePayoutStatus", "P1", status, "moved to "+status)
+	}
+	_, err = n.submit(roleFinance, "UpdatePayoutStatus", "P1", payoutStatusCancelled, "too late")
+	if err == nil || !strings.Contains(err.Error(), codeInvalidTransition) {
+		t.Errorf("Paid to Cancelled: got %v, want %s", err, codeInvalidTransition)
+	}
+
+	payout := decode[Payout](t, n.mustSubmit(roleAuditor, "GetPayout", "P1"))
+	if payout.Status != payoutStatusPaid || len(payout.Transitions) != len(steps)+1 {
+		t.Fatalf("payout is %s with %d transitions, want Paid with %d", payout.Status, len(payout.Transitions), len(steps)+1)
+	}
+	from := ""
+	for i, transition := range payout.Transitions {
+		want := payoutStatusPending
+		if i > 0 {
+			want = steps[i-1]
+		}
+		if transition.From != from || transition.To != want || transition.Reason == "" || transition.Actor == "" || transition.Timestamp == "" {
+			t.Errorf("transition %d is %+v, want %q to %q with a reason, actor and timestamp", i, transition, from, want)
+		}
+		from = want
+	}
+}
+
+func TestCancelledPayoutFreesItsSales(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "20.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(roleFinance, "CreatePayout", "P1", "R1", "20.00", "GBP", `["T1"]`, "false")
+	if _, err := n.submit(roleFinance, "CreatePayout", "P2", "R1", "20.00", "GBP", `["T1"]`, "false"); err == nil {
+		t.Fatal("sale already in a payout was paid again")
+	}
+
+	n.mustSubmit(roleFinance, "UpdatePayoutStatus", "P1", payoutStatusCancelled, "wrong bank details")
+	n.mustSubmit(roleFinance, "CreatePayout", "P2", "R1", "20.00", "GBP", `["T1"]`, "false")
+}
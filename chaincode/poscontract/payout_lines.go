@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	payoutLineSale           = "sale"
+	payoutLineRefund         = "refund"
+	payoutLineAdjustment     = "adjustment"
+	payoutLineCarryForward   = "carry_forward"
+	payoutLineReserve        = "reserve"
+	payoutLineReserveRelease = "reserve_release"
+)
+
+// PayoutLine is one record contributing to a payout's total. Sales are
+// positive, at their net amount, as are released reserves paid back and
+// negative balances carried forward to the next payout; refunds, adjustments
+// and reserves withheld are negative.
+// Lines of a group payout name the location the record belongs to.
+type PayoutLine struct {
+	Kind         string `json:"kind"`
+	RecordID     string `json:"record_id"`
+	RestaurantID string `json:"restaurant_id,omitempty" metadata:",optional"`
+	Amount       Money  `json:"amount"`
+}
+
+// PayoutLocation is one location's share of a group payout.
+type PayoutLocation struct {
+	RestaurantID string `json:"restaurant_id"`
+	Amount       Money  `json:"amount"`
+}
+
+// payoutItems holds the records a payout is made up of. restaurants are the
+// restaurants it pays, whose reserve terms apply to it. reserves and carried
+// are the reserves and carried-forward balances apply works out for it.
+type payoutItems struct {
+	transactions []*Transaction
+	refunds      []*Refund
+	adjustments  []*Adjustment
+	releases     []*Reserve
+	restaurants  map[string]*Restaurant
+	reserves     []*Reserve
+	carried      []*Adjustment
+}
+
+// apply sets the payout's lines, record ids, reserves and total from items,
+// and for a group payout the share of each location. A restaurant whose share
+// would be negative is paid nothing and the shortfall carried forward.
+func (items *payoutItems) apply(payout *Payout) error {
+	total := Money{Currency: payout.TotalAmount.Currency}
+	payout.TxIDs = []string{}
+	payout.RefundIDs = nil
+	payout.AdjustmentIDs = nil
+	payout.Lines = nil
+	payout.Locations = nil
+	payout.Reserved = nil
+
+	// owed is each restaurant's sales less refunds and adjustments, which its
+	// reserve is withheld from.
+	owed := map[string]int64{}
+	for _, tx := range items.transactions {
+		if err := payout.addLine(&total, payoutLineSale, tx.ID, tx.RestaurantID, tx.net()); err != nil {
+			return err
+		}
+		payout.TxIDs = append(payout.TxIDs, tx.ID)
+		owed[tx.RestaurantID] += tx.net().MinorUnits
+	}
+	for _, refund := range items.refunds {
+		deduction := Money{MinorUnits: -refund.Amount.MinorUnits, Currency: refund.Amount.Currency}
+		if err := payout.addLine(&total, payoutLineRefund, refund.ID, refund.RestaurantID, deduction); err != nil {
+			return err
+		}
+		payout.RefundIDs = append(payout.RefundIDs, refund.ID)
+		owed[refund.RestaurantID] -= refund.Amount.MinorUnits
+	}
+	for _, adjustment := range items.adjustments {
+		deduction := Money{MinorUnits: -adjustment.Amount.MinorUnits, Currency: adjustment.Amount.Currency}
+		if err := payout.addLine(&total, payoutLineAdjustment, adjustment.ID, adjustment.RestaurantID, deduction); err != nil {
+			return err
+		}
+		payout.AdjustmentIDs = append(payout.AdjustmentIDs, adjustment.ID)
+		owed[adjustment.RestaurantID] -= adjustment.Amount.MinorUnits
+	}
+	for _, reserve := range items.releases {
+		if err := payout.addLine(&total, payoutLineReserveRelease, reserve.ID, reserve.RestaurantID, reserve.Amount); err != nil {
+			return err
+		}
+	}
+	if err := items.withholdReserves(payout, &total, owed); err != nil {
+		return err
+	}
+	if err := items.carryForward(payout, &total); err != nil {
+		return err
+	}
+
+	payout.TotalAmount = total
+	if payout.GroupID != "" {
+		payout.Locations = locationShares(payout.Lines)
+	}
+	return nil
+}
+
+func (payout *Payout) addLine(total *Money, kind string, recordID string, restaurantID string, amount Money) error {
+	sum, err := total.Add(amount)
+	if err != nil {
+		return fmt.Errorf("%s %s cannot be added to payout %s: %v", kind, recordID, payout.ID, err)
+	}
+	*total = sum
+	line := PayoutLine{Kind: kind, RecordID: recordID, Amount: amount}
+	if payout.GroupID != "" {
+		line.RestaurantID = restaurantID
+	}
+	payout.Lines = append(payout.Lines, line)
+	return nil
+}
+
+// locationShares totals a group payout's lines by location, in order of
+// restaurant id.
+func locationShares(lines []PayoutLine) []PayoutLocation {
+	shares := map[string]*PayoutLocation{}
+	var restaurantIDs []string
+	for _, line := range lines {
+		share, ok := shares[line.RestaurantID]
+		if !ok {
+			share = &PayoutLocation{RestaurantID: line.RestaurantID, Amount: Money{Currency: line.Amount.Currency}}
+			shares[line.RestaurantID] = share
+			restaurantIDs = append(restaurantIDs, line.RestaurantID)
+		}
+		share.Amount.MinorUnits += line.Amount.MinorUnits
+	}
+	sort.Strings(restaurantIDs)
+
+	locations := make([]PayoutLocation, 0, len(restaurantIDs))
+	for _, restaurantID := range restaurantIDs {
+		locations = append(locations, *shares[restaurantID])
+	}
+	return locations
+}
+
+// lineRestaurant returns the restaurant a line of the payout belongs to.
+func (payout *Payout) lineRestaurant(line PayoutLine) string {
+	if line.RestaurantID != "" {
+		return line.RestaurantID
+	}
+	return payout.RestaurantID
+}
+
+// restaurantShares returns the amount of the payout owed to each restaurant
+// it pays: the per-location shares of a group payout, or the whole total.
+func (payout *Payout) restaurantShares() []PayoutLocation {
+	if payout.GroupID != "" {
+		return payout.Locations
+	}
+	return []PayoutLocation{{RestaurantID: payout.RestaurantID, Amount: payout.TotalAmount}}
+}
+
+// lock marks every record in items as paid out by payoutID so that no other
+// payout can include it, records the balances it carries forward and starts
+// holding the reserves it withholds.
+func (items *payoutItems) lock(ctx contractapi.TransactionContextInterface, payoutID string) error {
+	for _, tx := range items.transactions {
+		tx.PayoutID = payoutID
+		if err := putTransaction(ctx, tx); err != nil {
+			return err
+		}
+	}
+	for _, refund := range items.refunds {
+		refund.PayoutID = payoutID
+		if err := putRefund(ctx, refund); err != nil {
+			return err
+		}
+	}
+	for _, adjustment := range items.adjustments {
+		adjustment.PayoutID = payoutID
+		if err := putAdjustment(ctx, adjustment); err != nil {
+			return err
+		}
+	}
+	for _, reserve := range items.releases {
+		reserve.ReleasePayoutID = payoutID
+		if err := putReserve(ctx, reserve); err != nil {
+			return err
+		}
+	}
+	for _, adjustment := range items.carried {
+		if err := recordAdjustment(ctx, adjustment); err != nil {
+			return err
+		}
+	}
+	return items.holdReserves(ctx)
+}
+
+// pays reports whether items hold anything to pay out: sales or released
+// reserves. Refunds and adjustments on their own are only deducted.
+func (items *payoutItems) pays() bool {
+	return len(items.transactions) > 0 || len(items.releases) > 0
+}
+
+// loadPayoutTransactions checks that each of txIDs is a sale of payout's
+// restaurant, in its currency, that has not been paid out yet.
+func loadPayoutTransactions(ctx contractapi.TransactionContextInterface, payout *Payout, txIDs []string) ([]*Transaction, error) {
+	var transactions []*Transaction
+	seen := map[string]bool{}
+	for _, txID := range txIDs {
+		if seen[txID] {
+			return nil, fmt.Errorf("transaction %s is listed more than once in payout %s", txID, payout.ID)
+		}
+		seen[txID] = true
+
+		tx, err := readTransaction(ctx, txID)
+		if err != nil {
+			return nil, err
+		}
+		if tx.RestaurantID != payout.RestaurantID {
+			return nil, fmt.Errorf("transaction %s belongs to restaurant %s, not %s", txID, tx.RestaurantID, payout.RestaurantID)
+		}
+		if tx.PayoutID != "" {
+			return nil, fmt.Errorf("transaction %s is already included in payout %s", txID, tx.PayoutID)
+		}
+		if !tx.payable() {
+			return nil, fmt.Errorf("transaction %s is %s and cannot be paid out", txID, tx.Status)
+		}
+		if tx.Amount.Currency != payout.TotalAmount.Currency {
+			return nil, fmt.Errorf("transaction %s is in %s, payout %s is in %s", txID, tx.Amount.Currency, payout.ID, payout.TotalAmount.Currency)
+		}
+		transactions = append(transactions, tx)
+	}
+	return transactions, nil
+}
+
+// releasePayoutItems makes the records of a payout that will not be paid
+// available to a later payout again.
+func releasePayoutItems(ctx contractapi.TransactionContextInterface, payout *Payout) error {
+	if err := dropCarriedForward(ctx, payout); err != nil {
+		return err
+	}
+	for _, txID := range payout.TxIDs {
+		tx, err := findTransaction(ctx, txID)
+		if err != nil {
+			return err
+		}
+		if tx == nil || tx.PayoutID != payout.ID {
+			continue
+		}
+		tx.PayoutID = ""
+		if err := putTransaction(ctx, tx); err != nil {
+			return err
+		}
+	}
+	for _, refundID := range payout.RefundIDs {
+		refund, err := findRecord[Refund](ctx, refundObjectType, refundID)
+		if err != nil {
+			return err
+		}
+		if refund == nil || refund.PayoutID != payout.ID {
+			continue
+		}
+		refund.PayoutID = ""
+		if err := putRefund(ctx, refund); err != nil {
+			return err
+		}
+	}
+	for _, adjustmentID := range payout.AdjustmentIDs {
+		adjustment, err := findAdjustment(ctx, adjustmentID)
+		if err != nil {
+			return err
+		}
+		if adjustment == nil || adjustment.PayoutID != payout.ID {
+			continue
+		}
+		adjustment.PayoutID = ""
+		if err := putAdjustment(ctx, adjustment); err != nil {
+			return err
+		}
+	}
+	for _, line := range payout.Lines {
+		if line.Kind != payoutLineReserveRelease {
+			continue
+		}
+		reserve, err := findReserve(ctx, line.RecordID)
+		if err != nil {
+			return err
+		}
+		if reserve == nil || reserve.ReleasePayoutID != payout.ID {
+			continue
+		}
+		reserve.ReleasePayoutID = ""
+		if err := putReserve(ctx, reserve); err != nil {
+			return err
+		}
+	}
+	return nil
+}
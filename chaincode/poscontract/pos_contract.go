@@ -3,7 +3,6 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"strconv"
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -13,132 +12,563 @@ type SmartContract struct {
 	contractapi.Contract
 }
 
+const (
+	transactionObjectType = "txn"
+	payoutObjectType      = "payout"
+)
+
+// txStatusUpdated was set by earlier versions of UpdateTransaction; such
+// sales are otherwise treated as settled.
+const (
+	txStatusSettled = "Settled"
+	txStatusUpdated = "Updated"
+	txStatusVoided  = "Voided"
+)
+
+// Transaction is a sale. Amount is the gross charged to the customer; the
+// fees taken from it and what the restaurant is owed are worked out from the
+// restaurant's commission plan when the sale is recorded.
 type Transaction struct {
-	ID              string  `json:"id"`
-	RestaurantID    string  `json:"restaurant_id"`
-	Amount          float64 `json:"amount"`
-	StripePaymentID string  `json:"stripe_payment_id"`
-	Timestamp       string  `json:"timestamp"`
-	Status          string  `json:"status"`
+	ID              string       `json:"id"`
+	RestaurantID    string       `json:"restaurant_id"`
+	Amount          Money        `json:"amount"`
+	StripePaymentID string       `json:"stripe_payment_id"`
+	Timestamp       string       `json:"timestamp"`
+	BusinessTime    string       `json:"business_time,omitempty" metadata:",optional"`
+	Status          string       `json:"status"`
+	PayoutID        string       `json:"payout_id,omitempty" metadata:",optional"`
+	RefundedAmount  *Money       `json:"refunded_amount,omitempty" metadata:",optional"`
+	RefundIDs       []string     `json:"refund_ids,omitempty" metadata:",optional"`
+	DisputeIDs      []string     `json:"dispute_ids,omitempty" metadata:",optional"`
+	ChargedBack     *Money       `json:"charged_back_amount,omitempty" metadata:",optional"`
+	ProcessorFee    *Money       `json:"processor_fee,omitempty" metadata:",optional"`
+	Commission      *Money       `json:"commission,omitempty" metadata:",optional"`
+	NetAmount       *Money       `json:"net_amount,omitempty" metadata:",optional"`
+	CommissionPlan  string       `json:"commission_plan,omitempty" metadata:",optional"`
+	UpdatedAt       string       `json:"updated_at,omitempty" metadata:",optional"`
+	UpdatedBy       string       `json:"updated_by,omitempty" metadata:",optional"`
+	UpdateReason    string       `json:"update_reason,omitempty" metadata:",optional"`
+	Void            *VoidDetails `json:"void,omitempty" metadata:",optional"`
+
+	// Subtotal, Items, Taxes, Discounts and Tip break down an itemised sale,
+	// whose Amount is its subtotal less discounts plus taxes and tip.
+	Subtotal  *Money         `json:"subtotal,omitempty" metadata:",optional"`
+	Items     []LineItem     `json:"items,omitempty" metadata:",optional"`
+	Taxes     []TaxLine      `json:"taxes,omitempty" metadata:",optional"`
+	Discounts []DiscountLine `json:"discounts,omitempty" metadata:",optional"`
+	Tip       *Money         `json:"tip,omitempty" metadata:",optional"`
+}
+
+// VoidDetails records who voided a sale, when and why.
+type VoidDetails struct {
+	Reason   string `json:"reason"`
+	VoidedBy string `json:"voided_by"`
+	VoidedAt string `json:"voided_at"`
 }
 
+// Payout pays a restaurant what it is owed. A group payout pays a restaurant
+// group's head office instead: it has a GroupID in place of a RestaurantID
+// and breaks its total down by location in Locations. Reserved is the part of
+// the payout withheld under rolling reserves, which its total is net of.
 type Payout struct {
-	ID           string   `json:"id"`
-	RestaurantID string   `json:"restaurant_id"`
-	TotalAmount  float64  `json:"total_amount"`
-	TxIDs        []string `json:"tx_ids"`
-	Status       string   `json:"status"`
-	PayoutDate   string   `json:"payout_date"`
+	ID            string             `json:"id"`
+	RestaurantID  string             `json:"restaurant_id"`
+	TotalAmount   Money              `json:"total_amount"`
+	TxIDs         []string           `json:"tx_ids"`
+	RefundIDs     []string           `json:"refund_ids,omitempty" metadata:",optional"`
+	AdjustmentIDs []string           `json:"adjustment_ids,omitempty" metadata:",optional"`
+	Lines         []PayoutLine       `json:"lines,omitempty" metadata:",optional"`
+	Status        string             `json:"status"`
+	PayoutDate    string             `json:"payout_date"`
+	PeriodStart   string             `json:"period_start,omitempty" metadata:",optional"`
+	PeriodEnd     string             `json:"period_end,omitempty" metadata:",optional"`
+	GroupID       string             `json:"group_id,omitempty" metadata:",optional"`
+	Locations     []PayoutLocation   `json:"locations,omitempty" metadata:",optional"`
+	Reserved      *Money             `json:"reserved,omitempty" metadata:",optional"`
+	Transitions   []PayoutTransition `json:"transitions,omitempty" metadata:",optional"`
+}
+
+// RecordTransaction stores a sale. amount is a decimal in major units of
+// currency, e.g. "12.50" GBP, and must be greater than zero. businessTime is
+// the optional RFC 3339 time the sale happened at the terminal; the ledger
+// timestamp always comes from the proposal so every endorser writes the same
+// value. breakdown is an optional SaleBreakdown in JSON itemising the sale
+// into line items, taxes, discounts and tip, which must add up to amount.
+//
+// restaurantID must be a registered, active restaurant trading in currency.
+// Recording an id that is already on the ledger fails with an
+// AlreadyExistsError. With idempotent set, resubmitting the same sale returns
+// the stored record instead, so terminals can safely retry after a timeout.
+func (s *SmartContract) RecordTransaction(ctx contractapi.TransactionContextInterface, id string, restaurantID string, amount string, currency string, stripeID string, businessTime string, breakdown string, idempotent bool) (*Transaction, error) {
+	sale, err := parseSaleBreakdown(breakdown)
+	if err != nil {
+		return nil, err
+	}
+	tx, recorded, err := recordSale(ctx, id, restaurantID, amount, currency, stripeID, businessTime, sale, idempotent)
+	if err != nil {
+		return nil, err
+	}
+	if recorded {
+		if err := emitEvent(ctx, eventTransactionRecorded, tx); err != nil {
+			return nil, err
+		}
+	}
+	return tx, nil
 }
 
-func (s *SmartContract) RecordTransaction(ctx contractapi.TransactionContextInterface, id string, restaurantID string, amount float64, stripeID string) error {
-	tx := Transaction{
+// recordSale validates and writes a sale for RecordTransaction and
+// RecordTransactionsBatch, leaving the event to the caller. recorded is false
+// when an idempotent retry returned the stored sale instead.
+func recordSale(ctx contractapi.TransactionContextInterface, id string, restaurantID string, amount string, currency string, stripeID string, businessTime string, breakdown *SaleBreakdown, idempotent bool) (tx *Transaction, recorded bool, err error) {
+	money, err := parseMoney(amount, currency)
+	if err != nil {
+		return nil, false, err
+	}
+	if money.MinorUnits <= 0 {
+		return nil, false, &InvalidAmountError{Kind: "transaction", ID: id, Amount: money}
+	}
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	saleTime, err := parseBusinessTime(businessTime)
+	if err != nil {
+		return nil, false, err
+	}
+
+	tx = &Transaction{
 		ID:              id,
 		RestaurantID:    restaurantID,
-		Amount:          amount,
+		Amount:          money,
 		StripePaymentID: stripeID,
-		Timestamp:       time.Now().Format(time.RFC3339),
-		Status:          "Settled",
+		Timestamp:       timestamp,
+		BusinessTime:    saleTime,
+		Status:          txStatusSettled,
+	}
+	if err := tx.itemize(breakdown); err != nil {
+		return nil, false, err
+	}
+
+	existing, err := findTransaction(ctx, id)
+	if err != nil {
+		return nil, false, err
 	}
-	txBytes, _ := json.Marshal(tx)
-	return ctx.GetStub().PutState(id, txBytes)
+	if existing != nil {
+		if idempotent && existing.sameSale(tx) {
+			return existing, false, nil
+		}
+		return nil, false, &AlreadyExistsError{Kind: "transaction", ID: id}
+	}
+	restaurant, err := activeRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := restaurant.tradesIn(currency); err != nil {
+		return nil, false, err
+	}
+	if err := claimStripePayment(ctx, stripeID, id); err != nil {
+		return nil, false, err
+	}
+	if err := priceSale(ctx, tx, nil, restaurant); err != nil {
+		return nil, false, err
+	}
+	if err := changeBalance(ctx, restaurantID, currency, saleBalanceChange(tx)); err != nil {
+		return nil, false, err
+	}
+	if err := postSale(ctx, tx); err != nil {
+		return nil, false, err
+	}
+
+	if err := putTransaction(ctx, tx); err != nil {
+		return nil, false, err
+	}
+	if err := indexRestaurantRecord(ctx, transactionObjectType, restaurantID, timestamp, id); err != nil {
+		return nil, false, err
+	}
+	return tx, true, nil
 }
 
-func (s *SmartContract) CreatePayout(ctx contractapi.TransactionContextInterface, id string, restaurantID string, amount float64, txIDs []string) error {
+// CreatePayout pays out the given sales of an active restaurant, less any of
+// its refunds and adjustments not yet deducted from an earlier payout, plus
+// its released reserves not yet paid back and less its rolling reserve. Every
+// transaction must exist, belong to restaurantID, be in currency and not
+// already be part of another payout; amount must equal the resulting total.
+// The included sales, refunds and adjustments are marked with the payout id
+// so they cannot be paid out twice. If they leave the restaurant owed less
+// than nothing, the shortfall is carried forward to its next payout and the
+// amount is zero. A payout that would take more than the restaurant's
+// available balance is refused with INSUFFICIENT_BALANCE. txIDs may be empty
+// for a payout that only pays back released reserves, which is all a
+// suspended restaurant can be paid.
+// Duplicate ids follow the same rules as RecordTransaction.
+func (s *SmartContract) CreatePayout(ctx contractapi.TransactionContextInterface, id string, restaurantID string, amount string, currency string, txIDs []string, idempotent bool) (*Payout, error) {
+	total, err := parseMoney(amount, currency)
+	if err != nil {
+		return nil, err
+	}
+	created, err := newPayoutTransition(ctx, "", payoutStatusPending, "payout created")
+	if err != nil {
+		return nil, err
+	}
+
 	payout := Payout{
 		ID:           id,
 		RestaurantID: restaurantID,
-		TotalAmount:  amount,
+		TotalAmount:  total,
 		TxIDs:        txIDs,
-		Status:       "Pending",
-		PayoutDate:   time.Now().Format(time.RFC3339),
+		Status:       payoutStatusPending,
+		PayoutDate:   created.Timestamp,
+		Transitions:  []PayoutTransition{created},
+	}
+
+	existing, err := findPayout(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if idempotent && existing.samePayout(&payout) {
+			return existing, nil
+		}
+		return nil, &AlreadyExistsError{Kind: "payout", ID: id}
+	}
+	restaurant, err := payoutRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	if err := restaurant.tradesIn(currency); err != nil {
+		return nil, err
 	}
-	payoutBytes, _ := json.Marshal(payout)
-	return ctx.GetStub().PutState(id, payoutBytes)
+	if err := restaurant.paidIndividually(); err != nil {
+		return nil, err
+	}
+	if restaurant.Status == restaurantStatusSuspended && len(txIDs) > 0 {
+		return nil, fmt.Errorf("restaurant %s is %s and only its released reserves can be paid out", restaurantID, restaurant.Status)
+	}
+
+	transactions, err := loadPayoutTransactions(ctx, &payout, txIDs)
+	if err != nil {
+		return nil, err
+	}
+	refunds, err := outstandingRefunds(ctx, restaurantID, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	adjustments, err := outstandingAdjustments(ctx, restaurantID, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	releases, err := outstandingReleases(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	items := payoutItems{
+		transactions: transactions,
+		refunds:      refunds,
+		adjustments:  adjustments,
+		releases:     releases,
+		restaurants:  map[string]*Restaurant{restaurantID: restaurant},
+	}
+	if !items.pays() {
+		return nil, fmt.Errorf("payout %s has no transactions and restaurant %s has no released reserves to pay back", id, restaurantID)
+	}
+	if err := items.apply(&payout); err != nil {
+		return nil, err
+	}
+	if payout.TotalAmount != total {
+		return nil, fmt.Errorf("payout %s amount %s does not match its transactions less deductions and reserves, which total %s", id, total, payout.TotalAmount)
+	}
+	if err := items.lock(ctx, id); err != nil {
+		return nil, err
+	}
+
+	if err := putPayout(ctx, &payout); err != nil {
+		return nil, err
+	}
+	if err := applyNewPayoutBalances(ctx, &payout); err != nil {
+		return nil, err
+	}
+	if err := emitEvent(ctx, eventPayoutCreated, &payout); err != nil {
+		return nil, err
+	}
+	return &payout, nil
 }
 
-func (s *SmartContract) UpdatePayoutStatus(ctx contractapi.TransactionContextInterface, id string, newStatus string) error {
-	payoutBytes, err := ctx.GetStub().GetState(id)
-	if err != nil || payoutBytes == nil {
-		return fmt.Errorf("payout %s not found", id)
+// net returns what the restaurant is owed for the sale: its gross amount less
+// the processor fee and commission. Sales recorded before fees were worked
+// out on chain are owed in full.
+func (tx *Transaction) net() Money {
+	if tx.NetAmount == nil {
+		return tx.Amount
 	}
+	return *tx.NetAmount
+}
 
-	var payout Payout
-	json.Unmarshal(payoutBytes, &payout)
-	payout.Status = newStatus
+// payable reports whether the sale can still be included in a payout.
+// Fully refunded sales are still paid out, so that their refunds, which are
+// deducted as separate lines, net them to zero.
+func (tx *Transaction) payable() bool {
+	return tx.PayoutID == "" && (tx.refundable() || tx.Status == txStatusRefunded)
+}
 
-	payoutBytes, _ = json.Marshal(payout)
-	return ctx.GetStub().PutState(id, payoutBytes)
+// refundable reports whether the sale is in a status refunds can be taken
+// against. Refunds are allowed whether or not the sale has been paid out.
+func (tx *Transaction) refundable() bool {
+	switch tx.Status {
+	case txStatusSettled, txStatusUpdated, txStatusPartiallyRefunded:
+		return true
+	}
+	return false
 }
 
-func (s *SmartContract) GetRecord(ctx contractapi.TransactionContextInterface, id string) (string, error) {
-	recordBytes, err := ctx.GetStub().GetState(id)
-	if err != nil || recordBytes == nil {
-		return "", fmt.Errorf("record %s not found", id)
+// sameSale reports whether other describes the same sale, ignoring the ledger
+// timestamp, which differs on every submission.
+func (tx *Transaction) sameSale(other *Transaction) bool {
+	return tx.RestaurantID == other.RestaurantID &&
+		tx.Amount == other.Amount &&
+		tx.StripePaymentID == other.StripePaymentID &&
+		tx.BusinessTime == other.BusinessTime &&
+		tx.sameBreakdown(other)
+}
+
+// samePayout reports whether other requests the same payout, ignoring the
+// payout date.
+func (p *Payout) samePayout(other *Payout) bool {
+	if p.RestaurantID != other.RestaurantID || p.TotalAmount != other.TotalAmount || len(p.TxIDs) != len(other.TxIDs) {
+		return false
+	}
+	for i := range p.TxIDs {
+		if p.TxIDs[i] != other.TxIDs[i] {
+			return false
+		}
 	}
-	return string(recordBytes), nil
+	return true
 }
 
-func (s *SmartContract) GetAllRecords(ctx contractapi.TransactionContextInterface) ([]string, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
-	if err != nil {
-		return nil, err
+func (s *SmartContract) GetTransaction(ctx contractapi.TransactionContextInterface, id string) (*Transaction, error) {
+	return readTransaction(ctx, id)
+}
+
+func (s *SmartContract) GetPayout(ctx contractapi.TransactionContextInterface, id string) (*Payout, error) {
+	return readPayout(ctx, id)
+}
+
+func (s *SmartContract) ListTransactions(ctx contractapi.TransactionContextInterface) ([]*Transaction, error) {
+	return listRecords[Transaction](ctx, transactionObjectType)
+}
+
+func (s *SmartContract) ListPayouts(ctx contractapi.TransactionContextInterface) ([]*Payout, error) {
+	return listRecords[Payout](ctx, payoutObjectType)
+}
+
+func (s *SmartContract) GetRecord(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	for _, objectType := range recordObjectTypes {
+		key, err := ctx.GetStub().CreateCompositeKey(objectType, []string{id})
+		if err != nil {
+			return "", err
+		}
+		recordBytes, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return "", fmt.Errorf("failed to read from world state: %v", err)
+		}
+		if recordBytes != nil {
+			return string(recordBytes), nil
+		}
 	}
-	defer resultsIterator.Close()
+	return "", fmt.Errorf("record %s not found", id)
+}
 
+// GetAllRecords returns every record in one response. GetAllRecordsPage
+// reads them a page at a time.
+func (s *SmartContract) GetAllRecords(ctx contractapi.TransactionContextInterface) ([]string, error) {
 	var records []string
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
+	for _, objectType := range recordObjectTypes {
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{})
 		if err != nil {
 			return nil, err
 		}
-		records = append(records, string(queryResponse.Value))
+
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+			records = append(records, string(queryResponse.Value))
+		}
+		resultsIterator.Close()
 	}
 
 	return records, nil
 }
 
-func (s *SmartContract) UpdateTransaction(ctx contractapi.TransactionContextInterface, id string, restaurantId string, amountStr string, stripePaymentId string) error {
-	recordBytes, err := ctx.GetStub().GetState(id)
+// UpdateTransaction corrects the restaurant, amount or Stripe payment id of a
+// sale. The amount stays in the sale's currency and its original ledger
+// timestamp is kept; the change is stamped with the submitting identity, the
+// proposal time and reason. A sale can only be moved to an active restaurant
+// trading in its currency. Changing the amount or restaurant works the fees
+// out again under the restaurant's current commission plan. The amount of an
+// itemised sale cannot be changed.
+func (s *SmartContract) UpdateTransaction(ctx contractapi.TransactionContextInterface, id string, restaurantID string, amount string, stripePaymentID string, reason string) (*Transaction, error) {
+	if reason == "" {
+		return nil, fmt.Errorf("a reason is required to update transaction %s", id)
+	}
+	tx, err := readTransaction(ctx, id)
 	if err != nil {
-		return fmt.Errorf("failed to read from world state: %v", err)
+		return nil, err
 	}
-	if recordBytes == nil {
-		return fmt.Errorf("the record %s does not exist", id)
+	if tx.Status == txStatusVoided {
+		return nil, fmt.Errorf("transaction %s is voided and can no longer be changed", id)
+	}
+	if tx.PayoutID != "" {
+		return nil, fmt.Errorf("transaction %s is included in payout %s and can no longer be changed", id, tx.PayoutID)
+	}
+	if len(tx.RefundIDs) > 0 {
+		return nil, fmt.Errorf("transaction %s has refunds recorded against it and can no longer be changed", id)
+	}
+	if len(tx.DisputeIDs) > 0 {
+		return nil, fmt.Errorf("transaction %s has disputes recorded against it and can no longer be changed", id)
 	}
 
-	amount, err := strconv.ParseFloat(amountStr, 64)
+	money, err := parseMoney(amount, tx.Amount.Currency)
 	if err != nil {
-		return fmt.Errorf("amount must be a valid number: %v", err)
+		return nil, err
+	}
+	if money.MinorUnits <= 0 {
+		return nil, &InvalidAmountError{Kind: "transaction", ID: id, Amount: money}
+	}
+	if restaurantID == tx.RestaurantID && money == tx.Amount && stripePaymentID == tx.StripePaymentID {
+		return nil, fmt.Errorf("update to transaction %s does not change anything", id)
+	}
+	if money != tx.Amount && tx.Subtotal != nil {
+		return nil, fmt.Errorf("transaction %s is itemised and its amount cannot change without its breakdown", id)
+	}
+	var restaurant *Restaurant
+	if restaurantID != tx.RestaurantID {
+		restaurant, err = activeRestaurant(ctx, restaurantID)
+		if err != nil {
+			return nil, err
+		}
+		if err := restaurant.tradesIn(money.Currency); err != nil {
+			return nil, err
+		}
+	} else if money != tx.Amount {
+		restaurant, err = readRestaurant(ctx, restaurantID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if stripePaymentID != tx.StripePaymentID {
+		if err := claimStripePayment(ctx, stripePaymentID, id); err != nil {
+			return nil, err
+		}
+		if err := releaseStripePayment(ctx, tx.StripePaymentID); err != nil {
+			return nil, err
+		}
 	}
 
-	record := map[string]interface{}{
-		"id":                id,
-		"restaurant_id":     restaurantId,
-		"amount":            amount,
-		"stripe_payment_id": stripePaymentId,
-		"status":            "Updated",
-		"timestamp":         time.Now().Format(time.RFC3339),
+	actor, err := clientID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	newRecordBytes, _ := json.Marshal(record)
-	return ctx.GetStub().PutState(id, newRecordBytes)
+	previous := *tx
+	tx.RestaurantID = restaurantID
+	tx.Amount = money
+	tx.StripePaymentID = stripePaymentID
+	tx.UpdatedAt = timestamp
+	tx.UpdatedBy = actor
+	tx.UpdateReason = reason
+	if restaurantID != previous.RestaurantID {
+		if err := unindexRestaurantRecord(ctx, transactionObjectType, previous.RestaurantID, tx.Timestamp, id); err != nil {
+			return nil, err
+		}
+		if err := indexRestaurantRecord(ctx, transactionObjectType, restaurantID, tx.Timestamp, id); err != nil {
+			return nil, err
+		}
+	}
+	if restaurant != nil {
+		if err := priceSale(ctx, tx, &previous, restaurant); err != nil {
+			return nil, err
+		}
+		if err := moveSaleBalance(ctx, &previous, tx); err != nil {
+			return nil, err
+		}
+		if err := reverseSale(ctx, &previous); err != nil {
+			return nil, err
+		}
+		if err := postSale(ctx, tx); err != nil {
+			return nil, err
+		}
+	}
+	if err := putTransaction(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := emitEvent(ctx, eventTransactionUpdated, tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
 }
 
-func (s *SmartContract) DeleteTransaction(ctx contractapi.TransactionContextInterface, id string) error {
-	recordBytes, err := ctx.GetStub().GetState(id)
+// VoidTransaction cancels a sale while keeping it on the ledger with the
+// reason, voiding identity and time. Sales that have been paid out, refunded
+// or disputed cannot be voided.
+func (s *SmartContract) VoidTransaction(ctx contractapi.TransactionContextInterface, id string, reason string) (*Transaction, error) {
+	if reason == "" {
+		return nil, fmt.Errorf("a reason is required to void transaction %s", id)
+	}
+	tx, err := readTransaction(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if tx.Status == txStatusVoided {
+		return nil, fmt.Errorf("transaction %s is already voided", id)
+	}
+	if tx.PayoutID != "" {
+		return nil, fmt.Errorf("transaction %s is included in payout %s and cannot be voided", id, tx.PayoutID)
+	}
+	if len(tx.RefundIDs) > 0 {
+		return nil, fmt.Errorf("transaction %s has refunds recorded against it and cannot be voided", id)
+	}
+	if len(tx.DisputeIDs) > 0 {
+		return nil, fmt.Errorf("transaction %s has disputes recorded against it and cannot be voided", id)
+	}
+
+	actor, err := clientID(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to read from world state: %v", err)
+		return nil, err
 	}
-	if recordBytes == nil {
-		return fmt.Errorf("the record %s does not exist", id)
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return ctx.GetStub().DelState(id)
+	if err := removeSaleVolume(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := changeBalance(ctx, tx.RestaurantID, tx.Amount.Currency, saleBalanceChange(tx).negate()); err != nil {
+		return nil, err
+	}
+	if err := reverseSale(ctx, tx); err != nil {
+		return nil, err
+	}
+	tx.Status = txStatusVoided
+	tx.Void = &VoidDetails{Reason: reason, VoidedBy: actor, VoidedAt: timestamp}
+	if err := putTransaction(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := emitEvent(ctx, eventTransactionVoided, tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
 }
 
 func (s *SmartContract) GetHistory(ctx contractapi.TransactionContextInterface, id string) ([]map[string]interface{}, error) {
-	resultsIterator, err := ctx.GetStub().GetHistoryForKey(id)
+	resultsIterator, err := historyIterator(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get history for record %s: %v", id, err)
 	}
@@ -163,7 +593,7 @@ func (s *SmartContract) GetHistory(ctx contractapi.TransactionContextInterface,
 
 		historyEntry := map[string]interface{}{
 			"txId":      response.TxId,
-			"timestamp": time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).Format(time.RFC3339),
+			"timestamp": formatTimestamp(response.Timestamp.Seconds, response.Timestamp.Nanos),
 			"isDelete":  response.IsDelete,
 			"value":     record,
 		}
@@ -173,35 +603,130 @@ func (s *SmartContract) GetHistory(ctx contractapi.TransactionContextInterface,
 	return history, nil
 }
 
+// GetRecordsWithMetadata returns every record with its key and type.
+// GetRecordsWithMetadataPage reads them a page at a time.
 func (s *SmartContract) GetRecordsWithMetadata(ctx contractapi.TransactionContextInterface) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+	for _, objectType := range recordObjectTypes {
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{})
+		if err != nil {
+			return nil, err
+		}
+
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+
+			_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+
+			var entity interface{}
+			json.Unmarshal(queryResponse.Value, &entity)
+
+			record := map[string]interface{}{
+				"key":  attributes[0],
+				"type": objectType,
+				"data": entity,
+				"txId": "Click for History",
+			}
+			records = append(records, record)
+		}
+		resultsIterator.Close()
+	}
+	return records, nil
+}
+
+// MigrateFlatRecords re-keys records written under their raw id by earlier
+// versions of the contract into the transaction and payout namespaces, with
+// their timestamps in UTC, and indexes migrated transactions by restaurant
+// and by Stripe payment id. Where
+// legacy transactions share a payment id, only the first one migrated is
+// indexed by it.
+func (s *SmartContract) MigrateFlatRecords(ctx contractapi.TransactionContextInterface) (int, error) {
 	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	defer resultsIterator.Close()
 
-	var records []map[string]interface{}
+	migrated := 0
+	// Writes are not visible to reads within the same transaction, so
+	// payment ids indexed during this run are tracked here.
+	indexed := map[string]bool{}
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
-			return nil, err
+			return migrated, err
+		}
+
+		objectType := flatRecordObjectType(queryResponse.Value)
+		if objectType == "" {
+			continue
+		}
+
+		key, err := ctx.GetStub().CreateCompositeKey(objectType, []string{queryResponse.Key})
+		if err != nil {
+			return migrated, err
+		}
+		existing, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to read from world state: %v", err)
+		}
+		if existing != nil {
+			return migrated, fmt.Errorf("cannot migrate %s: a %s record with that id already exists", queryResponse.Key, objectType)
 		}
 
-		var entity interface{}
-		json.Unmarshal(queryResponse.Value, &entity)
+		value, err := utcRecordTimestamps(queryResponse.Value)
+		if err != nil {
+			return migrated, fmt.Errorf("cannot migrate %s: %v", queryResponse.Key, err)
+		}
+		if err := ctx.GetStub().PutState(key, value); err != nil {
+			return migrated, err
+		}
+		if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+			return migrated, err
+		}
+		migrated++
 
-		record := map[string]interface{}{
-			"key":  queryResponse.Key,
-			"data": entity,
-			"txId": "Click for History",
+		if objectType != transactionObjectType {
+			continue
+		}
+		var tx Transaction
+		if err := json.Unmarshal(value, &tx); err != nil {
+			return migrated, fmt.Errorf("failed to decode transaction %s: %v", queryResponse.Key, err)
+		}
+		if err := indexRestaurantRecord(ctx, transactionObjectType, tx.RestaurantID, tx.Timestamp, queryResponse.Key); err != nil {
+			return migrated, err
+		}
+		if tx.StripePaymentID == "" || indexed[tx.StripePaymentID] {
+			continue
 		}
-		records = append(records, record)
+		owner, err := lookupStripePayment(ctx, tx.StripePaymentID)
+		if err != nil {
+			return migrated, err
+		}
+		if owner == "" {
+			if err := claimStripePayment(ctx, tx.StripePaymentID, queryResponse.Key); err != nil {
+				return migrated, err
+			}
+		}
+		indexed[tx.StripePaymentID] = true
 	}
-	return records, nil
+
+	return migrated, nil
 }
 
 func main() {
-	chaincode, err := contractapi.NewChaincode(&SmartContract{})
+	contract := &SmartContract{}
+	contract.BeforeTransaction = authorizeCaller
+
+	chaincode, err := contractapi.NewChaincode(contract)
 	if err != nil {
 		fmt.Printf("Error creating POS chaincode: %s", err)
 		return
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecordTransactionRejectsAmountsNotAboveZero(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+
+	for _, amount := range []string{"-50.00", "0", "0.00"} {
+		_, err := n.submit(rolePOSTerminal, "RecordTransaction", "T1", "R1", amount, "GBP", "", "", "", "false")
+		if err == nil || !strings.Contains(err.Error(), codeInvalidAmount) {
+			t.Errorf("RecordTransaction of %s: got error %v, want %s", amount, err, codeInvalidAmount)
+		}
+	}
+
+	batch := `[{"id": "T2", "restaurant_id": "R1", "amount": "-5.00", "currency": "GBP"}]`
+	result := decode[BatchResult](t, n.mustSubmit(rolePOSTerminal, "RecordTransactionsBatch", batch, batchModeBestEffort, "false"))
+	if result.Recorded != 0 || !strings.Contains(result.Items[0].Error, codeInvalidAmount) {
+		t.Errorf("batch sale of -5.00: got %+v, want it rejected with %s", result.Items[0], codeInvalidAmount)
+	}
+
+	balance := decode[RestaurantBalance](t, n.mustSubmit(roleFinance, "GetRestaurantBalance", "R1"))
+	if balance.Available.MinorUnits != 0 || balance.GrossSales.MinorUnits != 0 {
+		t.Errorf("balance after rejected sales: available %s, gross sales %s, want both zero", balance.Available, balance.GrossSales)
+	}
+}
+
+func TestUpdateTransactionRejectsAmountsNotAboveZero(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "20.00", "GBP", "", "", "", "false")
+
+	_, err := n.submit(roleAdmin, "UpdateTransaction", "T1", "R1", "-20.00", "", "typo")
+	if err == nil || !strings.Contains(err.Error(), codeInvalidAmount) {
+		t.Errorf("UpdateTransaction to -20.00: got error %v, want %s", err, codeInvalidAmount)
+	}
+}
+
+func TestMigrateFlatRecordsStoresTimestampsInUTC(t *testing.T) {
+	n := newTestNetwork(t)
+	n.stub.MockTransactionStart("legacy")
+	n.stub.PutState("T1", []byte(`{"id":"T1","restaurant_id":"R1","amount":55.5,"stripe_payment_id":"ch_1","timestamp":"2025-06-01T10:00:00+02:00","status":"Settled"}`))
+	n.stub.PutState("P1", []byte(`{"id":"P1","restaurant_id":"R1","total_amount":55.5,"tx_ids":["T1"],"status":"Paid","payout_date":"2025-06-02T09:30:00-05:00"}`))
+	n.stub.MockTransactionEnd("legacy")
+
+	n.mustSubmit(roleAdmin, "MigrateFlatRecords")
+	tx := decode[Transaction](t, n.mustSubmit(roleAuditor, "GetTransaction", "T1"))
+	if tx.Timestamp != "2025-06-01T08:00:00Z" {
+		t.Errorf("migrated sale has timestamp %s, want 2025-06-01T08:00:00Z", tx.Timestamp)
+	}
+	payout := decode[Payout](t, n.mustSubmit(roleAuditor, "GetPayout", "P1"))
+	if payout.PayoutDate != "2025-06-02T14:30:00Z" {
+		t.Errorf("migrated payout has date %s, want 2025-06-02T14:30:00Z", payout.PayoutDate)
+	}
+}
+
+func TestCreatePayoutChecksItsTotal(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R2", "Bistro", "Bistro Ltd", "GBP", "")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "20.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T2", "R1", "30.00", "GBP", "ch_2", "", "", "false")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T3", "R2", "10.00", "GBP", "ch_3", "", "", "false")
+	n.mustSubmit(roleFinance, "RecordRefund", "RF1", "T2", "4.00", "re_1", "returned")
+
+	for name, args := range map[string][]string{
+		"wrong amount":              {"P1", "R1", "50.00", "GBP", `["T1","T2"]`, "false"},
+		"another restaurant's sale": {"P1", "R1", "56.00", "GBP", `["T1","T2","T3"]`, "false"},
+		"unknown sale":              {"P1", "R1", "46.00", "GBP", `["T1","T2","T9"]`, "false"},
+		"sale listed twice":         {"P1", "R1", "66.00", "GBP", `["T1","T2","T1"]`, "false"},
+		"other currency":            {"P1", "R1", "46.00", "EUR", `["T1","T2"]`, "false"},
+	} {
+		if _, err := n.submit(roleFinance, "CreatePayout", args...); err == nil {
+			t.Errorf("payout with %s was accepted", name)
+		}
+	}
+
+	payout := decode[Payout](t, n.mustSubmit(roleFinance, "CreatePayout", "P1", "R1", "46.00", "GBP", `["T1","T2"]`, "false"))
+	if strings.Join(payout.RefundIDs, ",") != "RF1" {
+		t.Errorf("payout deducts refunds %v, want RF1", payout.RefundIDs)
+	}
+	for _, id := range []string{"T1", "T2"} {
+		if tx := decode[Transaction](t, n.mustSubmit(roleAuditor, "GetTransaction", id)); tx.PayoutID != "P1" {
+			t.Errorf("%s is locked to payout %q, want P1", id, tx.PayoutID)
+		}
+	}
+}
+
+func TestVoidTransactionRules(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	for _, id := range []string{"T1", "T2", "T3", "T4"} {
+		n.mustSubmit(rolePOSTerminal, "RecordTransaction", id, "R1", "20.00", "GBP", "ch_"+id, "", "", "false")
+	}
+	n.mustSubmit(roleFinance, "CreatePayout", "P1", "R1", "20.00", "GBP", `["T2"]`, "false")
+	n.mustSubmit(roleFinance, "RecordRefund", "RF1", "T3", "5.00", "re_1", "returned")
+	n.mustSubmit(roleFinance, "OpenDispute", "D1", "T4", "20.00", "dp_1", "fraudulent")
+
+	if _, err := n.submit(roleAdmin, "VoidTransaction", "T1", ""); err == nil {
+		t.Error("void without a reason was accepted")
+	}
+	for id, state := range map[string]string{"T2": "paid out", "T3": "refunded", "T4": "disputed"} {
+		if _, err := n.submit(roleAdmin, "VoidTransaction", id, "entered twice"); err == nil {
+			t.Errorf("void of a %s sale was accepted", state)
+		}
+	}
+
+	tx := decode[Transaction](t, n.mustSubmit(roleAdmin, "VoidTransaction", "T1", "entered twice"))
+	if tx.Status != txStatusVoided || tx.Void == nil || tx.Void.Reason != "entered twice" || tx.Void.VoidedBy == "" || tx.Void.VoidedAt == "" {
+		t.Errorf("voided sale is %s with %+v, want Voided with the reason, identity and time", tx.Status, tx.Void)
+	}
+	if _, err := n.submit(roleAdmin, "VoidTransaction", "T1", "again"); err == nil {
+		t.Error("second void of the same sale was accepted")
+	}
+	if kept := decode[Transaction](t, n.mustSubmit(roleAuditor, "GetTransaction", "T1")); kept.Status != txStatusVoided {
+		t.Errorf("voided sale reads back as %s, want it kept as Voided", kept.Status)
+	}
+	if _, err := n.submit(roleFinance, "CreatePayout", "P2", "R1", "20.00", "GBP", `["T1"]`, "false"); err == nil {
+		t.Error("payout of a voided sale was accepted")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const refundObjectType = "refund"
+
+const (
+	txStatusPartiallyRefunded = "PartiallyRefunded"
+	txStatusRefunded          = "Refunded"
+)
+
+// Refund returns part or all of a sale to the customer. Refunds are paid out
+// as deductions from the restaurant's next payout.
+type Refund struct {
+	ID             string `json:"id"`
+	TransactionID  string `json:"transaction_id"`
+	RestaurantID   string `json:"restaurant_id"`
+	Amount         Money  `json:"amount"`
+	StripeRefundID string `json:"stripe_refund_id"`
+	Reason         string `json:"reason"`
+	Timestamp      string `json:"timestamp"`
+	PayoutID       string `json:"payout_id,omitempty" metadata:",optional"`
+}
+
+// RecordRefund refunds amount, a decimal in major units of the sale's
+// currency, against originalTxID. A sale may be refunded several times as
+// long as its refunds and the disputes on it that have not been won do not
+// add up to more than the original amount, so that money under dispute is
+// not refunded as well.
+func (s *SmartContract) RecordRefund(ctx contractapi.TransactionContextInterface, refundID string, originalTxID string, amount string, stripeRefundID string, reason string) (*Refund, error) {
+	existing, err := findRecord[Refund](ctx, refundObjectType, refundID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, &AlreadyExistsError{Kind: "refund", ID: refundID}
+	}
+
+	tx, err := readTransaction(ctx, originalTxID)
+	if err != nil {
+		return nil, err
+	}
+	if !tx.refundable() {
+		return nil, fmt.Errorf("transaction %s is %s and cannot be refunded", originalTxID, tx.Status)
+	}
+
+	money, err := parseMoney(amount, tx.Amount.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if money.MinorUnits <= 0 {
+		return nil, fmt.Errorf("refund amount must be greater than zero")
+	}
+
+	refunded := Money{Currency: tx.Amount.Currency}
+	if tx.RefundedAmount != nil {
+		refunded = *tx.RefundedAmount
+	}
+	refunded, err = refunded.Add(money)
+	if err != nil {
+		return nil, err
+	}
+	if refunded.MinorUnits > tx.Amount.MinorUnits {
+		return nil, fmt.Errorf("refund of %s would take the refunds on transaction %s to %s, more than the sale amount of %s", money, originalTxID, refunded, tx.Amount)
+	}
+	disputed, err := disputedAmount(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if refunded.MinorUnits+disputed.MinorUnits > tx.Amount.MinorUnits {
+		return nil, fmt.Errorf("refund of %s and the %s disputed on transaction %s would exceed the sale amount of %s", money, disputed, originalTxID, tx.Amount)
+	}
+
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	refund := Refund{
+		ID:             refundID,
+		TransactionID:  originalTxID,
+		RestaurantID:   tx.RestaurantID,
+		Amount:         money,
+		StripeRefundID: stripeRefundID,
+		Reason:         reason,
+		Timestamp:      timestamp,
+	}
+	if err := putRefund(ctx, &refund); err != nil {
+		return nil, err
+	}
+	if err := indexRestaurantRecord(ctx, refundObjectType, refund.RestaurantID, timestamp, refundID); err != nil {
+		return nil, err
+	}
+	if err := changeBalance(ctx, refund.RestaurantID, money.Currency, refundBalanceChange(&refund)); err != nil {
+		return nil, err
+	}
+	if err := postRefund(ctx, &refund); err != nil {
+		return nil, err
+	}
+
+	tx.RefundedAmount = &refunded
+	tx.RefundIDs = append(tx.RefundIDs, refundID)
+	tx.Status = txStatusPartiallyRefunded
+	if refunded == tx.Amount {
+		tx.Status = txStatusRefunded
+	}
+	if err := putTransaction(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := emitEvent(ctx, eventRefundRecorded, &refund); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+func (s *SmartContract) GetRefund(ctx contractapi.TransactionContextInterface, id string) (*Refund, error) {
+	return readRefund(ctx, id)
+}
+
+func (s *SmartContract) ListRefunds(ctx contractapi.TransactionContextInterface) ([]*Refund, error) {
+	return listRecords[Refund](ctx, refundObjectType)
+}
+
+// outstandingRefunds returns the refunds of restaurantID that no payout has
+// deducted yet, limited to those recorded before cutoff unless it is zero.
+func outstandingRefunds(ctx contractapi.TransactionContextInterface, restaurantID string, cutoff time.Time) ([]*Refund, error) {
+	refunds, err := listRestaurantRecords[Refund](ctx, refundObjectType, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var outstanding []*Refund
+	for _, refund := range refunds {
+		if refund.PayoutID != "" {
+			continue
+		}
+		if !cutoff.IsZero() {
+			recorded, err := time.Parse(time.RFC3339, refund.Timestamp)
+			if err != nil {
+				return nil, fmt.Errorf("refund %s has an unreadable timestamp %q", refund.ID, refund.Timestamp)
+			}
+			if !recorded.Before(cutoff) {
+				continue
+			}
+		}
+		outstanding = append(outstanding, refund)
+	}
+	return outstanding, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const reserveObjectType = "reserve"
+
+const (
+	reserveStatusHeld      = "Held"
+	reserveStatusReleased  = "Released"
+	reserveStatusCancelled = "Cancelled"
+)
+
+// Reserve is part of a payout withheld from a restaurant under its rolling
+// reserve. It is Held from when the payout is created until at least
+// ReleaseAfter. Once the payout has been paid and that date has passed,
+// ReleaseReserves makes it available to the restaurant again and the next
+// payout, named in ReleasePayoutID, pays it back. A reserve is Cancelled
+// with the payout it was withheld from.
+type Reserve struct {
+	ID              string `json:"id"`
+	RestaurantID    string `json:"restaurant_id"`
+	PayoutID        string `json:"payout_id"`
+	Amount          Money  `json:"amount"`
+	Status          string `json:"status"`
+	HeldAt          string `json:"held_at"`
+	ReleaseAfter    string `json:"release_after"`
+	ReleasedAt      string `json:"released_at,omitempty" metadata:",optional"`
+	ReleasePayoutID string `json:"release_payout_id,omitempty" metadata:",optional"`
+}
+
+// SetRestaurantReserve sets a restaurant's rolling reserve: rateBasisPoints
+// of its sales less refunds in each payout are withheld for days days. A
+// rate of zero stops withholding. The terms apply to payouts created from
+// then on; reserves already held keep their release date.
+func (s *SmartContract) SetRestaurantReserve(ctx contractapi.TransactionContextInterface, restaurantID string, rateBasisPoints int64, days int) (*Restaurant, error) {
+	if err := validateRate(rateBasisPoints); err != nil {
+		return nil, fmt.Errorf("invalid reserve rate: %v", err)
+	}
+	if rateBasisPoints == 0 {
+		days = 0
+	} else if days <= 0 {
+		return nil, fmt.Errorf("a reserve must be held for at least one day")
+	}
+	restaurant, err := readRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+
+	restaurant.ReserveRateBasisPoints = rateBasisPoints
+	restaurant.ReserveDays = days
+	if err := stampRestaurant(ctx, restaurant); err != nil {
+		return nil, err
+	}
+	if err := putRestaurant(ctx, restaurant); err != nil {
+		return nil, err
+	}
+	return restaurant, nil
+}
+
+// ReleaseReserves releases every reserve held from a restaurant whose release
+// date has passed and whose payout has been paid, making it available to be
+// paid back by the restaurant's next payout. It returns the reserves
+// released, which may be none.
+func (s *SmartContract) ReleaseReserves(ctx contractapi.TransactionContextInterface, restaurantID string) ([]*Reserve, error) {
+	restaurant, err := readRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reserves, err := listRestaurantRecords[Reserve](ctx, reserveObjectType, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+
+	released := []*Reserve{}
+	var change balanceChange
+	for _, reserve := range reserves {
+		if reserve.Status != reserveStatusHeld || reserve.ReleaseAfter > timestamp {
+			continue
+		}
+		payout, err := readPayout(ctx, reserve.PayoutID)
+		if err != nil {
+			return nil, err
+		}
+		if payout.Status != payoutStatusPaid {
+			continue
+		}
+
+		reserve.Status = reserveStatusReleased
+		reserve.ReleasedAt = timestamp
+		if err := putReserve(ctx, reserve); err != nil {
+			return nil, err
+		}
+		if err := postReserveRelease(ctx, reserve); err != nil {
+			return nil, err
+		}
+		change = change.plus(reserveBalanceChange(reserve.Amount).negate())
+		released = append(released, reserve)
+	}
+	if err := changeBalance(ctx, restaurantID, restaurant.Currency, change); err != nil {
+		return nil, err
+	}
+	return released, nil
+}
+
+// GetRestaurantReserves lists the reserves withheld from a restaurant, oldest
+// first.
+func (s *SmartContract) GetRestaurantReserves(ctx contractapi.TransactionContextInterface, restaurantID string) ([]*Reserve, error) {
+	if _, err := readRestaurant(ctx, restaurantID); err != nil {
+		return nil, err
+	}
+	return listRestaurantRecords[Reserve](ctx, reserveObjectType, restaurantID)
+}
+
+// withholdReserves adds a line to the payout withholding each restaurant's
+// reserve from what it is owed for the payout's sales less refunds, and sets
+// the reserves and the payout's Reserved total. Restaurants owed nothing have
+// nothing withheld.
+func (items *payoutItems) withholdReserves(payout *Payout, total *Money, owed map[string]int64) error {
+	items.reserves = nil
+	var restaurantIDs []string
+	for restaurantID := range owed {
+		restaurantIDs = append(restaurantIDs, restaurantID)
+	}
+	sort.Strings(restaurantIDs)
+
+	reserved := Money{Currency: total.Currency}
+	for _, restaurantID := range restaurantIDs {
+		restaurant := items.restaurants[restaurantID]
+		if restaurant == nil || restaurant.ReserveRateBasisPoints == 0 || owed[restaurantID] <= 0 {
+			continue
+		}
+		amount := percentageOf(Money{MinorUnits: owed[restaurantID], Currency: total.Currency}, restaurant.ReserveRateBasisPoints)
+		if amount.MinorUnits == 0 {
+			continue
+		}
+		reserve := &Reserve{
+			ID:           fmt.Sprintf("reserve-%s-%s", payout.ID, restaurantID),
+			RestaurantID: restaurantID,
+			PayoutID:     payout.ID,
+			Amount:       amount,
+			Status:       reserveStatusHeld,
+		}
+		withheld := Money{MinorUnits: -amount.MinorUnits, Currency: amount.Currency}
+		if err := payout.addLine(total, payoutLineReserve, reserve.ID, restaurantID, withheld); err != nil {
+			return err
+		}
+		reserved.MinorUnits += amount.MinorUnits
+		items.reserves = append(items.reserves, reserve)
+	}
+	if reserved.MinorUnits != 0 {
+		payout.Reserved = &reserved
+	}
+	return nil
+}
+
+// holdReserves starts holding the reserves a new payout withholds, each until
+// its restaurant's reserve period has passed.
+func (items *payoutItems) holdReserves(ctx contractapi.TransactionContextInterface) error {
+	if len(items.reserves) == 0 {
+		return nil
+	}
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	held, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return err
+	}
+	for _, reserve := range items.reserves {
+		days := items.restaurants[reserve.RestaurantID].ReserveDays
+		reserve.HeldAt = timestamp
+		reserve.ReleaseAfter = held.AddDate(0, 0, days).Format(time.RFC3339)
+		if err := putReserve(ctx, reserve); err != nil {
+			return err
+		}
+		if err := indexRestaurantRecord(ctx, reserveObjectType, reserve.RestaurantID, reserve.HeldAt, reserve.ID); err != nil {
+			return err
+		}
+		if err := postReserveHold(ctx, reserve); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelPayoutReserves cancels the reserves withheld from a payout that is
+// being cancelled and returns them. They are all still held, since reserves
+// are only released once their payout has been paid.
+func cancelPayoutReserves(ctx contractapi.TransactionContextInterface, payout *Payout) ([]*Reserve, error) {
+	var cancelled []*Reserve
+	for _, line := range payout.Lines {
+		if line.Kind != payoutLineReserve {
+			continue
+		}
+		reserve, err := findReserve(ctx, line.RecordID)
+		if err != nil {
+			return nil, err
+		}
+		if reserve == nil || reserve.Status != reserveStatusHeld {
+			continue
+		}
+		reserve.Status = reserveStatusCancelled
+		if err := putReserve(ctx, reserve); err != nil {
+			return nil, err
+		}
+		if err := reverseReserveHold(ctx, reserve); err != nil {
+			return nil, err
+		}
+		cancelled = append(cancelled, reserve)
+	}
+	return cancelled, nil
+}
+
+// outstandingReleases returns the restaurant's released reserves that no
+// payout has paid back yet.
+func outstandingReleases(ctx contractapi.TransactionContextInterface, restaurantID string) ([]*Reserve, error) {
+	reserves, err := listRestaurantRecords[Reserve](ctx, reserveObjectType, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	var outstanding []*Reserve
+	for _, reserve := range reserves {
+		if reserve.Status == reserveStatusReleased && reserve.ReleasePayoutID == "" {
+			outstanding = append(outstanding, reserve)
+		}
+	}
+	return outstanding, nil
+}
+
+// withheld returns the reserve the payout withholds from a restaurant it
+// pays.
+func (payout *Payout) withheld(restaurantID string) Money {
+	amount := Money{Currency: payout.TotalAmount.Currency}
+	for _, line := range payout.Lines {
+		if line.Kind != payoutLineReserve {
+			continue
+		}
+		if payout.lineRestaurant(line) == restaurantID {
+			amount.MinorUnits -= line.Amount.MinorUnits
+		}
+	}
+	return amount
+}
+
+// findReserve returns nil, nil when id is not on the ledger.
+func findReserve(ctx contractapi.TransactionContextInterface, id string) (*Reserve, error) {
+	return findRecord[Reserve](ctx, reserveObjectType, id)
+}
+
+func putReserve(ctx contractapi.TransactionContextInterface, reserve *Reserve) error {
+	return putRecord(ctx, reserveObjectType, reserve.ID, reserve)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// payReserveWithheld records a sale, pays it out less a 10% reserve and
+// releases the reserve once its eight days have passed.
+func payReserveWithheld(t *testing.T, n *testNetwork) {
+	t.Helper()
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	n.mustSubmit(roleAdmin, "SetRestaurantReserve", "R1", "1000", "7")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "100.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(roleFinance, "CreatePayout", "P1", "R1", "90.00", "GBP", `["T1"]`, "false")
+	markPaid(t, n, "P1")
+
+	n.advance(8 * 24 * time.Hour)
+	released := decode[[]*Reserve](t, n.mustSubmit(roleFinance, "ReleaseReserves", "R1"))
+	if len(*released) != 1 {
+		t.Fatalf("released %d reserves, want 1", len(*released))
+	}
+}
+
+func markPaid(t *testing.T, n *testNetwork, payoutID string) {
+	t.Helper()
+	for _, status := range []string{payoutStatusApproved, payoutStatusSubmitted, payoutStatusPaid} {
+		n.mustSubmit(roleFinance, "UpdatePayoutStatus", payoutID, status, "settlement run")
+	}
+}
+
+func TestReleasedReservePaidOutWithoutSales(t *testing.T) {
+	n := newTestNetwork(t)
+	payReserveWithheld(t, n)
+
+	payout := decode[Payout](t, n.mustSubmit(roleFinance, "GeneratePayout", "R1", "2025-01-01T00:00:00Z", "2026-01-01T00:00:00Z"))
+	if payout.TotalAmount.MinorUnits != 1000 || len(payout.TxIDs) != 0 {
+		t.Fatalf("payout pays %s for %d sales, want 10.00 GBP for none", payout.TotalAmount, len(payout.TxIDs))
+	}
+	markPaid(t, n, payout.ID)
+
+	restaurant := decode[Restaurant](t, n.mustSubmit(roleAdmin, "OffboardRestaurant", "R1", "closed down"))
+	if restaurant.Status != restaurantStatusOffboarded || restaurant.ClawbackID != "" {
+		t.Errorf("offboarded restaurant is %s with clawback %q, want Offboarded with none", restaurant.Status, restaurant.ClawbackID)
+	}
+}
+
+func TestSuspendedRestaurantPaidItsReleasedReserve(t *testing.T) {
+	n := newTestNetwork(t)
+	payReserveWithheld(t, n)
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T2", "R1", "30.00", "GBP", "ch_2", "", "", "false")
+	n.mustSubmit(roleAdmin, "SuspendRestaurant", "R1", "stopped trading")
+
+	if _, err := n.submit(roleFinance, "CreatePayout", "P2", "R1", "37.00", "GBP", `["T2"]`, "false"); err == nil {
+		t.Fatal("payout of a suspended restaurant's sale was accepted")
+	}
+	n.mustSubmit(roleFinance, "CreatePayout", "P2", "R1", "10.00", "GBP", `[]`, "false")
+	markPaid(t, n, "P2")
+
+	if _, err := n.submit(roleFinance, "GeneratePayout", "R1", "2025-01-01T00:00:00Z", "2026-01-01T00:00:00Z"); err == nil {
+		t.Error("payout with nothing left to pay back was accepted")
+	}
+	balance := decode[RestaurantBalance](t, n.mustSubmit(roleFinance, "GetRestaurantBalance", "R1"))
+	if balance.Available.MinorUnits != 3000 || balance.Reserved.MinorUnits != 0 {
+		t.Errorf("available %s and reserved %s, want the unpaid sale of 30.00 GBP available and nothing reserved", balance.Available, balance.Reserved)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const restaurantObjectType = "restaurant"
+
+const (
+	restaurantStatusActive     = "Active"
+	restaurantStatusSuspended  = "Suspended"
+	restaurantStatusOffboarded = "Offboarded"
+)
+
+// Restaurant is a merchant on the network. Sales and payouts may only be
+// recorded for registered, active restaurants, in the restaurant's currency.
+type Restaurant struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	LegalEntity      string `json:"legal_entity"`
+	Currency         string `json:"currency"`
+	CommissionPlan   string `json:"commission_plan"`
+	Status           string `json:"status"`
+	RegisteredAt     string `json:"registered_at"`
+	UpdatedAt        string `json:"updated_at,omitempty" metadata:",optional"`
+	UpdatedBy        string `json:"updated_by,omitempty" metadata:",optional"`
+	SuspensionReason string `json:"suspension_reason,omitempty" metadata:",optional"`
+	GroupID          string `json:"group_id,omitempty" metadata:",optional"`
+	OffboardReason   string `json:"offboard_reason,omitempty" metadata:",optional"`
+	ClawbackID       string `json:"clawback_id,omitempty" metadata:",optional"`
+
+	// ReserveRateBasisPoints and ReserveDays are the restaurant's rolling
+	// reserve: the share of each payout withheld and for how long.
+	ReserveRateBasisPoints int64 `json:"reserve_rate_bps,omitempty" metadata:",optional"`
+	ReserveDays            int   `json:"reserve_days,omitempty" metadata:",optional"`
+}
+
+// RegisterRestaurant adds a restaurant to the registry. Its currency cannot
+// be changed later, since its sales and payouts are denominated in it. An
+// empty commission plan means no fees are taken from its sales.
+func (s *SmartContract) RegisterRestaurant(ctx contractapi.TransactionContextInterface, id string, name string, legalEntity string, currency string, commissionPlan string) (*Restaurant, error) {
+	if id == "" || name == "" || legalEntity == "" {
+		return nil, fmt.Errorf("restaurant id, name and legal entity are required")
+	}
+	if _, err := currencyExponent(currency); err != nil {
+		return nil, err
+	}
+
+	if err := checkCommissionPlan(ctx, commissionPlan, currency); err != nil {
+		return nil, err
+	}
+
+	existing, err := findRestaurant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, &AlreadyExistsError{Kind: "restaurant", ID: id}
+	}
+
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	restaurant := Restaurant{
+		ID:             id,
+		Name:           name,
+		LegalEntity:    legalEntity,
+		Currency:       currency,
+		CommissionPlan: commissionPlan,
+		Status:         restaurantStatusActive,
+		RegisteredAt:   timestamp,
+	}
+	if err := putRestaurant(ctx, &restaurant); err != nil {
+		return nil, err
+	}
+	return &restaurant, nil
+}
+
+// UpdateRestaurant changes a restaurant's name, legal entity and commission
+// plan. A new plan applies to sales recorded from then on.
+func (s *SmartContract) UpdateRestaurant(ctx contractapi.TransactionContextInterface, id string, name string, legalEntity string, commissionPlan string) (*Restaurant, error) {
+	if name == "" || legalEntity == "" {
+		return nil, fmt.Errorf("restaurant name and legal entity are required")
+	}
+	restaurant, err := readRestaurant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCommissionPlan(ctx, commissionPlan, restaurant.Currency); err != nil {
+		return nil, err
+	}
+
+	restaurant.Name = name
+	restaurant.LegalEntity = legalEntity
+	restaurant.CommissionPlan = commissionPlan
+	if err := stampRestaurant(ctx, restaurant); err != nil {
+		return nil, err
+	}
+	if err := putRestaurant(ctx, restaurant); err != nil {
+		return nil, err
+	}
+	return restaurant, nil
+}
+
+// SuspendRestaurant stops new sales, and payouts of its sales, for a
+// restaurant until it is reinstated. Refunds against its existing sales are
+// still accepted and its released reserves can still be paid out.
+func (s *SmartContract) SuspendRestaurant(ctx contractapi.TransactionContextInterface, id string, reason string) (*Restaurant, error) {
+	if reason == "" {
+		return nil, fmt.Errorf("a reason is required to suspend restaurant %s", id)
+	}
+	restaurant, err := readRestaurant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if restaurant.Status != restaurantStatusActive {
+		return nil, &InvalidTransitionError{Kind: "restaurant", ID: id, From: restaurant.Status, To: restaurantStatusSuspended}
+	}
+
+	restaurant.Status = restaurantStatusSuspended
+	restaurant.SuspensionReason = reason
+	if err := stampRestaurant(ctx, restaurant); err != nil {
+		return nil, err
+	}
+	if err := putRestaurant(ctx, restaurant); err != nil {
+		return nil, err
+	}
+	return restaurant, nil
+}
+
+// ReinstateRestaurant makes a suspended restaurant active again.
+func (s *SmartContract) ReinstateRestaurant(ctx contractapi.TransactionContextInterface, id string) (*Restaurant, error) {
+	restaurant, err := readRestaurant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if restaurant.Status != restaurantStatusSuspended {
+		return nil, &InvalidTransitionError{Kind: "restaurant", ID: id, From: restaurant.Status, To: restaurantStatusActive}
+	}
+
+	restaurant.Status = restaurantStatusActive
+	restaurant.SuspensionReason = ""
+	if err := stampRestaurant(ctx, restaurant); err != nil {
+		return nil, err
+	}
+	if err := putRestaurant(ctx, restaurant); err != nil {
+		return nil, err
+	}
+	return restaurant, nil
+}
+
+func (s *SmartContract) GetRestaurant(ctx contractapi.TransactionContextInterface, id string) (*Restaurant, error) {
+	return readRestaurant(ctx, id)
+}
+
+func (s *SmartContract) ListRestaurants(ctx contractapi.TransactionContextInterface) ([]*Restaurant, error) {
+	return listRecords[Restaurant](ctx, restaurantObjectType)
+}
+
+// stampRestaurant records who changed the restaurant and when.
+func stampRestaurant(ctx contractapi.TransactionContextInterface, restaurant *Restaurant) error {
+	actor, err := clientID(ctx)
+	if err != nil {
+		return err
+	}
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	restaurant.UpdatedAt = timestamp
+	restaurant.UpdatedBy = actor
+	return nil
+}
+
+// activeRestaurant returns the restaurant with the given id, failing unless
+// it is registered and active.
+func activeRestaurant(ctx contractapi.TransactionContextInterface, id string) (*Restaurant, error) {
+	restaurant, err := findRestaurant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if restaurant == nil {
+		return nil, fmt.Errorf("restaurant %s is not registered", id)
+	}
+	if restaurant.Status != restaurantStatusActive {
+		return nil, fmt.Errorf("restaurant %s is %s", id, restaurant.Status)
+	}
+	return restaurant, nil
+}
+
+// payoutRestaurant returns the restaurant with the given id for a payout,
+// failing unless it is registered and active or suspended. A suspended
+// restaurant's sales are not paid out, but its released reserves are, so
+// that a restaurant that has stopped trading can be paid what it is owed and
+// offboarded.
+func payoutRestaurant(ctx contractapi.TransactionContextInterface, id string) (*Restaurant, error) {
+	restaurant, err := findRestaurant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if restaurant == nil {
+		return nil, fmt.Errorf("restaurant %s is not registered", id)
+	}
+	if restaurant.Status != restaurantStatusActive && restaurant.Status != restaurantStatusSuspended {
+		return nil, fmt.Errorf("restaurant %s is %s", id, restaurant.Status)
+	}
+	return restaurant, nil
+}
+
+// checkCommissionPlan fails unless id is empty or names a commission plan on
+// the ledger whose fixed amounts are in currency.
+func checkCommissionPlan(ctx contractapi.TransactionContextInterface, id string, currency string) error {
+	if id == "" {
+		return nil
+	}
+	plan, err := readCommissionPlan(ctx, id)
+	if err != nil {
+		return err
+	}
+	return plan.chargesIn(currency)
+}
+
+// tradesIn fails unless the restaurant's sales and payouts are in currency.
+func (r *Restaurant) tradesIn(currency string) error {
+	if currency != r.Currency {
+		return fmt.Errorf("restaurant %s trades in %s, not %s", r.ID, r.Currency, currency)
+	}
+	return nil
+}
+
+func readRestaurant(ctx contractapi.TransactionContextInterface, id string) (*Restaurant, error) {
+	restaurant, err := findRestaurant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if restaurant == nil {
+		return nil, fmt.Errorf("restaurant %s not found", id)
+	}
+	return restaurant, nil
+}
+
+// findRestaurant returns nil, nil when id is not registered.
+func findRestaurant(ctx contractapi.TransactionContextInterface, id string) (*Restaurant, error) {
+	return findRecord[Restaurant](ctx, restaurantObjectType, id)
+}
+
+func putRestaurant(ctx contractapi.TransactionContextInterface, restaurant *Restaurant) error {
+	return putRecord(ctx, restaurantObjectType, restaurant.ID, restaurant)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// restaurantIndexObjectType namespaces the
+// restaurant_index~<objectType>~<restaurantID>~<timestamp>~<id> entries that
+// list a restaurant's sales, refunds, adjustments and reserves in the order
+// they were recorded. Payouts and balances read a restaurant's records
+// through them, so that they range over that restaurant's slice of the
+// ledger rather than every record of the kind.
+const restaurantIndexObjectType = "restaurant_index"
+
+// restaurantIndexedTypes are the namespaces whose records are indexed by
+// restaurant.
+var restaurantIndexedTypes = []string{transactionObjectType, refundObjectType, adjustmentObjectType, reserveObjectType}
+
+func restaurantIndexKey(ctx contractapi.TransactionContextInterface, objectType string, restaurantID string, timestamp string, id string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(restaurantIndexObjectType, []string{objectType, restaurantID, timestamp, id})
+}
+
+// indexRestaurantRecord adds the record of objectType with id, recorded at
+// timestamp, to the records of restaurantID.
+func indexRestaurantRecord(ctx contractapi.TransactionContextInterface, objectType string, restaurantID string, timestamp string, id string) error {
+	key, err := restaurantIndexKey(ctx, objectType, restaurantID, timestamp, id)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, []byte{0x00})
+}
+
+// unindexRestaurantRecord removes a record from the records of restaurantID.
+func unindexRestaurantRecord(ctx contractapi.TransactionContextInterface, objectType string, restaurantID string, timestamp string, id string) error {
+	key, err := restaurantIndexKey(ctx, objectType, restaurantID, timestamp, id)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// listRestaurantRecords decodes the records of objectType belonging to
+// restaurantID, oldest first.
+func listRestaurantRecords[T any](ctx contractapi.TransactionContextInterface, objectType string, restaurantID string) ([]*T, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(restaurantIndexObjectType, []string{objectType, restaurantID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	records := []*T{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		id := attributes[len(attributes)-1]
+		record, err := findRecord[T](ctx, objectType, id)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			return nil, fmt.Errorf("%s %s of restaurant %s is indexed but not on the ledger", objectType, id, restaurantID)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// restaurantIndexEntry is what the index needs of a record, whichever
+// namespace it is in. Reserves are recorded when they are held.
+type restaurantIndexEntry struct {
+	ID           string `json:"id"`
+	RestaurantID string `json:"restaurant_id"`
+	Timestamp    string `json:"timestamp"`
+	HeldAt       string `json:"held_at"`
+}
+
+// IndexRestaurantRecords indexes by restaurant every sale, refund,
+// adjustment and reserve on the ledger, and returns how many it indexed.
+// Records written from this version of the contract on are indexed as they
+// are recorded; run it once after upgrading so that payouts and balances
+// also find the ones recorded before. Running it again changes nothing.
+func (s *SmartContract) IndexRestaurantRecords(ctx contractapi.TransactionContextInterface) (int, error) {
+	indexed := 0
+	for _, objectType := range restaurantIndexedTypes {
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{})
+		if err != nil {
+			return indexed, err
+		}
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return indexed, err
+			}
+			var entry restaurantIndexEntry
+			if err := json.Unmarshal(queryResponse.Value, &entry); err != nil {
+				resultsIterator.Close()
+				return indexed, fmt.Errorf("failed to decode %s: %v", queryResponse.Key, err)
+			}
+			timestamp := entry.Timestamp
+			if objectType == reserveObjectType {
+				timestamp = entry.HeldAt
+			}
+			if err := indexRestaurantRecord(ctx, objectType, entry.RestaurantID, timestamp, entry.ID); err != nil {
+				resultsIterator.Close()
+				return indexed, err
+			}
+			indexed++
+		}
+		resultsIterator.Close()
+	}
+	return indexed, nil
+}
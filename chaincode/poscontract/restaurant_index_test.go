@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const wholePeriod = "2026-01-01T00:00:00Z"
+
+func TestPayoutFollowsSaleMovedToAnotherRestaurant(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R2", "Bistro", "Bistro Ltd", "GBP", "")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "20.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T2", "R2", "30.00", "GBP", "ch_2", "", "", "false")
+	n.mustSubmit(roleAdmin, "UpdateTransaction", "T1", "R2", "20.00", "ch_1", "wrong restaurant")
+
+	if _, err := n.submit(roleFinance, "GeneratePayout", "R1", "2025-01-01T00:00:00Z", wholePeriod); err == nil {
+		t.Error("payout of a restaurant whose only sale moved away was accepted")
+	}
+	payout := decode[Payout](t, n.mustSubmit(roleFinance, "GeneratePayout", "R2", "2025-01-01T00:00:00Z", wholePeriod))
+	if strings.Join(payout.TxIDs, ",") != "T1,T2" || payout.TotalAmount.MinorUnits != 5000 {
+		t.Errorf("payout pays %s for %v, want 50.00 GBP for T1 and T2", payout.TotalAmount, payout.TxIDs)
+	}
+}
+
+func TestIndexRestaurantRecordsFindsEarlierRecords(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "20.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(roleFinance, "RecordRefund", "RF1", "T1", "5.00", "re_1", "returned")
+	// Records written before the index existed have no entries in it.
+	for key := range n.stub.State {
+		if strings.HasPrefix(key, "\x00"+restaurantIndexObjectType+"\x00") {
+			n.stub.DelState(key)
+		}
+	}
+	if _, err := n.submit(roleFinance, "GeneratePayout", "R1", "2025-01-01T00:00:00Z", wholePeriod); err == nil {
+		t.Fatal("payout found sales that are not indexed")
+	}
+
+	indexed := decode[int](t, n.mustSubmit(roleAdmin, "IndexRestaurantRecords"))
+	if *indexed != 2 {
+		t.Errorf("indexed %d records, want 2", *indexed)
+	}
+	payout := decode[Payout](t, n.mustSubmit(roleFinance, "GeneratePayout", "R1", "2025-01-01T00:00:00Z", wholePeriod))
+	if payout.TotalAmount.MinorUnits != 1500 || len(payout.RefundIDs) != 1 {
+		t.Errorf("payout pays %s less refunds %v, want 15.00 GBP less RF1", payout.TotalAmount, payout.RefundIDs)
+	}
+}
+
+func TestMigrateFlatRecordsIndexesTransactions(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "20.00", "GBP", "ch_1", "", "", "false")
+	// Earlier versions of the contract kept the sale under its raw id, with
+	// no entry in the index.
+	key, _ := n.stub.CreateCompositeKey(transactionObjectType, []string{"T1"})
+	value := n.stub.State[key]
+	n.stub.DelState(key)
+	for key := range n.stub.State {
+		if strings.HasPrefix(key, "\x00"+restaurantIndexObjectType+"\x00") {
+			n.stub.DelState(key)
+		}
+	}
+	n.stub.MockTransactionStart("legacy")
+	n.stub.PutState("T1", value)
+	n.stub.MockTransactionEnd("legacy")
+
+	migrated := decode[int](t, n.mustSubmit(roleAdmin, "MigrateFlatRecords"))
+	if *migrated != 1 {
+		t.Fatalf("migrated %d records, want 1", *migrated)
+	}
+	payout := decode[Payout](t, n.mustSubmit(roleFinance, "GeneratePayout", "R1", "2025-01-01T00:00:00Z", wholePeriod))
+	if strings.Join(payout.TxIDs, ",") != "T1" {
+		t.Errorf("payout pays %v, want the migrated T1", payout.TxIDs)
+	}
+}
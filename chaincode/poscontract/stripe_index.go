@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// stripeIndexObjectType namespaces the stripe~<paymentID> entries that map a
+// Stripe payment id to the transaction recording it.
+const stripeIndexObjectType = "stripe"
+
+func stripeIndexKey(ctx contractapi.TransactionContextInterface, paymentID string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(stripeIndexObjectType, []string{paymentID})
+}
+
+// lookupStripePayment returns the id of the transaction that recorded
+// paymentID, or "" if none has.
+func lookupStripePayment(ctx contractapi.TransactionContextInterface, paymentID string) (string, error) {
+	key, err := stripeIndexKey(ctx, paymentID)
+	if err != nil {
+		return "", err
+	}
+	txID, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from world state: %v", err)
+	}
+	return string(txID), nil
+}
+
+// claimStripePayment indexes paymentID against txID, failing if another
+// transaction already holds it. Empty payment ids are not indexed.
+func claimStripePayment(ctx contractapi.TransactionContextInterface, paymentID string, txID string) error {
+	if paymentID == "" {
+		return nil
+	}
+	owner, err := lookupStripePayment(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+	if owner == txID {
+		return nil
+	}
+	if owner != "" {
+		return &AlreadyExistsError{Kind: "stripe payment", ID: paymentID}
+	}
+
+	key, err := stripeIndexKey(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, []byte(txID))
+}
+
+func releaseStripePayment(ctx contractapi.TransactionContextInterface, paymentID string) error {
+	if paymentID == "" {
+		return nil
+	}
+	key, err := stripeIndexKey(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+func (s *SmartContract) GetTransactionByStripeID(ctx contractapi.TransactionContextInterface, paymentID string) (*Transaction, error) {
+	txID, err := lookupStripePayment(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if txID == "" {
+		return nil, fmt.Errorf("no transaction found for stripe payment %s", paymentID)
+	}
+	return readTransaction(ctx, txID)
+}
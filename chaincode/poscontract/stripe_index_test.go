@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripePaymentIDRecordedOnce(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "20.00", "GBP", "ch_1", "", "", "false")
+
+	_, err := n.submit(rolePOSTerminal, "RecordTransaction", "T2", "R1", "20.00", "GBP", "ch_1", "", "", "false")
+	if err == nil || !strings.Contains(err.Error(), codeAlreadyExists) {
+		t.Fatalf("second sale for ch_1: got %v, want %s", err, codeAlreadyExists)
+	}
+	if tx := decode[Transaction](t, n.mustSubmit(roleAuditor, "GetTransactionByStripeID", "ch_1")); tx.ID != "T1" {
+		t.Errorf("ch_1 maps to %s, want T1", tx.ID)
+	}
+}
+
+func TestUpdateTransactionMovesStripePaymentID(t *testing.T) {
+	n := newTestNetwork(t)
+	n.mustSubmit(roleAdmin, "RegisterRestaurant", "R1", "Cafe", "Cafe Ltd", "GBP", "")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T1", "R1", "20.00", "GBP", "ch_1", "", "", "false")
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T2", "R1", "30.00", "GBP", "ch_2", "", "", "false")
+
+	_, err := n.submit(roleAdmin, "UpdateTransaction", "T2", "R1", "30.00", "ch_1", "wrong payment")
+	if err == nil || !strings.Contains(err.Error(), codeAlreadyExists) {
+		t.Fatalf("correcting T2 to T1's payment id: got %v, want %s", err, codeAlreadyExists)
+	}
+
+	n.mustSubmit(roleAdmin, "UpdateTransaction", "T1", "R1", "20.00", "ch_9", "wrong payment")
+	if tx := decode[Transaction](t, n.mustSubmit(roleAuditor, "GetTransactionByStripeID", "ch_9")); tx.ID != "T1" {
+		t.Errorf("ch_9 maps to %s, want T1", tx.ID)
+	}
+	if _, err := n.submit(roleAuditor, "GetTransactionByStripeID", "ch_1"); err == nil {
+		t.Error("ch_1 still maps to a sale after T1 moved off it")
+	}
+	n.mustSubmit(rolePOSTerminal, "RecordTransaction", "T3", "R1", "20.00", "GBP", "ch_1", "", "", "false")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TransactionQuery selects sales. Every field is optional and the ones set
+// are combined. MinAmount and MaxAmount are inclusive decimals in major units
+// of Currency, which they require. From and To bound the ledger timestamp as
+// RFC 3339 times, From inclusive and To exclusive.
+type TransactionQuery struct {
+	RestaurantID string `json:"restaurant_id"`
+	Status       string `json:"status"`
+	Currency     string `json:"currency"`
+	MinAmount    string `json:"min_amount"`
+	MaxAmount    string `json:"max_amount"`
+	From         string `json:"from"`
+	To           string `json:"to"`
+}
+
+// TransactionPage is one page of sales. Bookmark is passed back to fetch the
+// next page and is empty once there are no more.
+type TransactionPage struct {
+	Records      []*Transaction `json:"records"`
+	Bookmark     string         `json:"bookmark"`
+	FetchedCount int32          `json:"fetched_count"`
+}
+
+// QueryTransactions runs a CouchDB rich query for the sales matching
+// queryJSON, a JSON TransactionQuery. It is taken as a string because
+// contractapi cannot describe a parameter whose fields are all optional. The
+// indexes under META-INF/statedb/couchdb/indexes cover each criterion. Rich
+// queries need a CouchDB state database and, being paginated, can only be
+// evaluated.
+func (s *SmartContract) QueryTransactions(ctx contractapi.TransactionContextInterface, queryJSON string, pageSize int32, bookmark string) (*TransactionPage, error) {
+	if pageSize < 1 || pageSize > maxPageSize {
+		return nil, fmt.Errorf("page size must be between 1 and %d", maxPageSize)
+	}
+	var query TransactionQuery
+	if err := json.Unmarshal([]byte(queryJSON), &query); err != nil {
+		return nil, fmt.Errorf("query must be a JSON object: %v", err)
+	}
+	selector, err := query.selector()
+	if err != nil {
+		return nil, err
+	}
+	queryBytes, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryBytes), pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	page := &TransactionPage{Records: []*Transaction{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var tx Transaction
+		if err := json.Unmarshal(queryResponse.Value, &tx); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %v", queryResponse.Key, err)
+		}
+		page.Records = append(page.Records, &tx)
+	}
+	page.FetchedCount = int32(len(page.Records))
+	// CouchDB hands back a bookmark even after the last page.
+	if page.FetchedCount == pageSize {
+		page.Bookmark = metadata.Bookmark
+	}
+	return page, nil
+}
+
+// selector builds the Mango selector for the query. Only sales have a
+// stripe_payment_id, which keeps payouts, refunds and restaurants out.
+// CouchDB only uses an index whose fields are all in the selector, so
+// restaurant and status each have a single-field index besides the ones
+// they share with timestamp.
+func (query *TransactionQuery) selector() (map[string]interface{}, error) {
+	selector := map[string]interface{}{
+		"stripe_payment_id": map[string]interface{}{"$exists": true},
+	}
+	if query.RestaurantID != "" {
+		selector["restaurant_id"] = query.RestaurantID
+	}
+	if query.Status != "" {
+		selector["status"] = query.Status
+	}
+
+	if query.MinAmount != "" || query.MaxAmount != "" {
+		if query.Currency == "" {
+			return nil, fmt.Errorf("an amount range needs a currency")
+		}
+		amount := map[string]interface{}{}
+		if query.MinAmount != "" {
+			min, err := parseMoney(query.MinAmount, query.Currency)
+			if err != nil {
+				return nil, err
+			}
+			amount["$gte"] = min.MinorUnits
+		}
+		if query.MaxAmount != "" {
+			max, err := parseMoney(query.MaxAmount, query.Currency)
+			if err != nil {
+				return nil, err
+			}
+			amount["$lte"] = max.MinorUnits
+		}
+		selector["amount.minor_units"] = amount
+	}
+	if query.Currency != "" {
+		if _, err := currencyExponent(query.Currency); err != nil {
+			return nil, err
+		}
+		selector["amount.currency"] = query.Currency
+	}
+
+	// Ledger timestamps are stored as fixed-width UTC RFC 3339 strings, so
+	// they compare in time order. Legacy flat records were written with the
+	// peer's local offset; MigrateFlatRecords rewrites them in UTC, and until
+	// it has run they are matched by the text of their timestamp, not its
+	// time.
+	if query.From != "" || query.To != "" {
+		timestamp := map[string]interface{}{}
+		if query.From != "" {
+			from, err := time.Parse(time.RFC3339, query.From)
+			if err != nil {
+				return nil, fmt.Errorf("from must be an RFC 3339 timestamp: %v", err)
+			}
+			timestamp["$gte"] = from.UTC().Format(time.RFC3339)
+		}
+		if query.To != "" {
+			to, err := time.Parse(time.RFC3339, query.To)
+			if err != nil {
+				return nil, fmt.Errorf("to must be an RFC 3339 timestamp: %v", err)
+			}
+			timestamp["$lt"] = to.UTC().Format(time.RFC3339)
+		}
+		selector["timestamp"] = timestamp
+	}
+	return selector, nil
+}